@@ -18,6 +18,12 @@ type (
 		Del(ctx context.Context, key string) error
 	}
 
+	// NXCache is implemented by the caches which can set a key only if it does not exist atomically.
+	NXCache interface {
+		Cache
+		SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error)
+	}
+
 	ObjectCache interface {
 		Get(ctx context.Context, key string, dst any) error
 		Set(ctx context.Context, key string, val any, exp time.Duration) error
@@ -47,6 +53,32 @@ func (c *cacheWithOptions) Del(ctx context.Context, key string) error {
 	return c.cache.Del(ctx, c.prefix+key)
 }
 
+// nxCacheWithOptions is returned by With if the cache implements NXCache, so the atomicity is kept.
+type nxCacheWithOptions struct {
+	*cacheWithOptions
+	nx NXCache
+}
+
+func (c *nxCacheWithOptions) SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error) {
+	return c.nx.SetNX(ctx, c.prefix+key, value, exp)
+}
+
+// SetNX sets the key only if it does not exist, it is atomic if the cache implements NXCache,
+// otherwise it falls back to Get and Set.
+func SetNX(ctx context.Context, cache Cache, key string, value []byte, exp time.Duration) (bool, error) {
+	if c, ok := cache.(NXCache); ok {
+		return c.SetNX(ctx, key, value, exp)
+	}
+	_, err := cache.Get(ctx, key)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrCacheNotFound) {
+		return false, err
+	}
+	return true, cache.Set(ctx, key, value, exp)
+}
+
 type Option func(o *cacheWithOptions)
 
 func WithPrefix(prefix string) Option {
@@ -61,11 +93,15 @@ func WithIgnoreNotFound() Option {
 	}
 }
 
+// With wraps the cache with the options, the result implements NXCache only if the cache does.
 func With(cache Cache, options ...Option) Cache {
 	o := &cacheWithOptions{cache: cache}
 	for _, option := range options {
 		option(o)
 	}
+	if nx, ok := cache.(NXCache); ok {
+		return &nxCacheWithOptions{cacheWithOptions: o, nx: nx}
+	}
 	return o
 }
 
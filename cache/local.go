@@ -15,6 +15,7 @@ type (
 	}
 
 	localCache struct {
+		mu      sync.Mutex
 		list    list.List
 		storage sync.Map
 		maxLen  int
@@ -22,28 +23,45 @@ type (
 )
 
 func (c *localCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element := c.get(key)
+	if element == nil {
+		return nil, ErrCacheNotFound
+	}
+	return element.data, nil
+}
+
+func (c *localCache) get(key string) *cacheElement {
 	val, ok := c.storage.Load(key)
 	if !ok {
-		return nil, ErrCacheNotFound
+		return nil
 	}
 	e, _ := val.(*list.Element)
 	element, _ := e.Value.(*cacheElement)
 	if element.exp.Before(time.Now()) {
 		c.list.Remove(e)
 		c.storage.Delete(key)
-		return nil, ErrCacheNotFound
+		return nil
 	}
-	return element.data, nil
+	return element
 }
 
 func (c *localCache) Set(_ context.Context, key string, value []byte, exp time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, exp)
+	return nil
+}
+
+func (c *localCache) set(key string, value []byte, exp time.Duration) {
 	val, ok := c.storage.Load(key)
 	if ok {
 		e, _ := val.(*list.Element)
 		element, _ := e.Value.(*cacheElement)
 		element.data = value
 		element.exp = time.Now().Add(exp)
-		return nil
+		return
 	}
 	if c.list.Len() >= c.maxLen {
 		e := c.list.Front()
@@ -53,10 +71,21 @@ func (c *localCache) Set(_ context.Context, key string, value []byte, exp time.D
 	}
 	element := &cacheElement{key: key, data: value, exp: time.Now().Add(exp)}
 	c.storage.Store(key, c.list.PushBack(element))
-	return nil
+}
+
+func (c *localCache) SetNX(_ context.Context, key string, value []byte, exp time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.get(key) != nil {
+		return false, nil
+	}
+	c.set(key, value, exp)
+	return true, nil
 }
 
 func (c *localCache) Del(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	e, ok := c.storage.Load(key)
 	if !ok {
 		return nil
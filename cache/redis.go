@@ -24,6 +24,10 @@ func (c *redisCache) Set(ctx context.Context, key string, value []byte, exp time
 	return c.client.Set(ctx, key, value, exp).Err()
 }
 
+func (c *redisCache) SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, value, exp).Result()
+}
+
 func (c *redisCache) Del(ctx context.Context, key string) error {
 	return c.client.Del(ctx, key).Err()
 }
@@ -0,0 +1,292 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const redacted = "[REDACTED]"
+
+// AccessLogger writes an access log record for each request with the ClientInfo of the request,
+// it should be used after clients.Middleware or the clients server interceptors.
+type AccessLogger struct {
+	logger        *slog.Logger
+	level         slog.Level
+	sampling      float64
+	headers       []string
+	redactHeaders map[string]bool
+	redactQuery   map[string]bool
+}
+
+type AccessLogOption func(*AccessLogger)
+
+// WithAccessLogLogger set the logger, slog.Default() is used by default.
+func WithAccessLogLogger(logger *slog.Logger) AccessLogOption {
+	return func(a *AccessLogger) {
+		a.logger = logger
+	}
+}
+
+// WithAccessLogLevel set the level of the successful requests, the failed requests are always
+// logged with slog.LevelError.
+func WithAccessLogLevel(level slog.Level) AccessLogOption {
+	return func(a *AccessLogger) {
+		a.level = level
+	}
+}
+
+// WithAccessLogSampling set the ratio (0~1) of the successful requests to be logged,
+// the failed and panicked requests are always logged.
+func WithAccessLogSampling(ratio float64) AccessLogOption {
+	return func(a *AccessLogger) {
+		a.sampling = ratio
+	}
+}
+
+// WithAccessLogHeaders add the request headers (or incoming metadata of gRPC) to be logged.
+func WithAccessLogHeaders(headers ...string) AccessLogOption {
+	return func(a *AccessLogger) {
+		a.headers = append(a.headers, headers...)
+	}
+}
+
+// WithAccessLogRedactHeaders add the headers whose value is replaced by [REDACTED],
+// Authorization and Cookie are redacted by default.
+func WithAccessLogRedactHeaders(headers ...string) AccessLogOption {
+	return func(a *AccessLogger) {
+		for _, header := range headers {
+			a.redactHeaders[strings.ToLower(header)] = true
+		}
+	}
+}
+
+// WithAccessLogRedactQuery add the query parameters whose value is replaced by [REDACTED].
+func WithAccessLogRedactQuery(keys ...string) AccessLogOption {
+	return func(a *AccessLogger) {
+		for _, key := range keys {
+			a.redactQuery[key] = true
+		}
+	}
+}
+
+func NewAccessLogger(options ...AccessLogOption) *AccessLogger {
+	a := &AccessLogger{
+		level:    slog.LevelInfo,
+		sampling: 1,
+		redactHeaders: map[string]bool{
+			"authorization": true,
+			"cookie":        true,
+		},
+		redactQuery: map[string]bool{},
+	}
+	for _, option := range options {
+		option(a)
+	}
+	return a
+}
+
+func (a *AccessLogger) sampled() bool {
+	return a.sampling >= 1 || rand.Float64() < a.sampling
+}
+
+func (a *AccessLogger) log(ctx context.Context, failed bool, msg string, attrs ...slog.Attr) {
+	level := a.level
+	if failed {
+		level = slog.LevelError
+	} else if !a.sampled() {
+		return
+	}
+	logger := a.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	if info := FromContext(ctx); info != nil {
+		attrs = append(attrs,
+			slog.String("request_id", info.RequestID),
+			slog.String("client_id", info.ID),
+			slog.String("ip", info.IP),
+			slog.String("ua", info.UA),
+			slog.String("version", info.Version),
+		)
+		if tenant := info.Tenant(); tenant != "" {
+			attrs = append(attrs, slog.String("tenant", tenant))
+		}
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (a *AccessLogger) header(key string, values []string) slog.Attr {
+	if a.redactHeaders[strings.ToLower(key)] {
+		return slog.String(key, redacted)
+	}
+	return slog.String(key, strings.Join(values, ","))
+}
+
+func (a *AccessLogger) query(r *http.Request) string {
+	if r.URL.RawQuery == "" || len(a.redactQuery) == 0 {
+		return r.URL.RawQuery
+	}
+	query := r.URL.Query()
+	for key := range query {
+		if a.redactQuery[key] {
+			query[key] = []string{redacted}
+		}
+	}
+	return query.Encode()
+}
+
+func panicAttr(p any) slog.Attr {
+	return slog.Group("panic",
+		slog.String("error", fmt.Sprint(p)),
+		slog.String("stack", string(debug.Stack())),
+	)
+}
+
+func (a *AccessLogger) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := NewResponseWriter(w)
+			defer func() {
+				p := recover()
+				route := r.Pattern
+				if route == "" {
+					route = r.URL.Path
+				}
+				code := rw.Status()
+				if p != nil && !rw.Written() {
+					code = http.StatusInternalServerError
+				}
+				attrs := []slog.Attr{
+					slog.String("method", r.Method),
+					slog.String("route", route),
+					slog.String("path", r.URL.Path),
+					slog.String("query", a.query(r)),
+					slog.Int("status", code),
+					slog.Int64("bytes", rw.Bytes()),
+					slog.Duration("latency", time.Since(start)),
+				}
+				if len(a.headers) > 0 {
+					headers := make([]any, 0, len(a.headers))
+					for _, key := range a.headers {
+						headers = append(headers, a.header(key, r.Header.Values(key)))
+					}
+					attrs = append(attrs, slog.Group("headers", headers...))
+				}
+				if p != nil {
+					attrs = append(attrs, panicAttr(p))
+				}
+				a.log(r.Context(), p != nil || code >= http.StatusInternalServerError, "http access", attrs...)
+				if p != nil {
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func (a *AccessLogger) grpcAttrs(ctx context.Context, method string, err error, bytes int64, start time.Time) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("status", status.Code(err).String()),
+		slog.Int64("bytes", bytes),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	if len(a.headers) > 0 {
+		md := incomingMD(ctx)
+		headers := make([]any, 0, len(a.headers))
+		for _, key := range a.headers {
+			headers = append(headers, a.header(key, md.Get(key)))
+		}
+		attrs = append(attrs, slog.Group("headers", headers...))
+	}
+	return attrs
+}
+
+func grpcFailed(err error) bool {
+	switch status.Code(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *AccessLogger) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		start := time.Now()
+		defer func() {
+			p := recover()
+			if p != nil {
+				err = status.Errorf(codes.Internal, "panic: %v", p)
+			}
+			var bytes int64
+			if m, ok := resp.(proto.Message); ok && err == nil {
+				bytes = int64(proto.Size(m))
+			}
+			attrs := a.grpcAttrs(ctx, info.FullMethod, err, bytes, start)
+			if p != nil {
+				attrs = append(attrs, panicAttr(p))
+			}
+			a.log(ctx, p != nil || grpcFailed(err), "grpc access", attrs...)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+type countingServerStream struct {
+	grpc.ServerStream
+	bytes int64
+}
+
+func (s *countingServerStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if msg, ok := m.(proto.Message); ok && err == nil {
+		s.bytes += int64(proto.Size(msg))
+	}
+	return err
+}
+
+func (a *AccessLogger) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		stream := &countingServerStream{ServerStream: ss}
+		defer func() {
+			p := recover()
+			if p != nil {
+				err = status.Errorf(codes.Internal, "panic: %v", p)
+			}
+			ctx := ss.Context()
+			attrs := a.grpcAttrs(ctx, info.FullMethod, err, stream.bytes, start)
+			if p != nil {
+				attrs = append(attrs, panicAttr(p))
+			}
+			a.log(ctx, p != nil || grpcFailed(err), "grpc access", attrs...)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		return handler(srv, stream)
+	}
+}
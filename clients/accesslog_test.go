@@ -0,0 +1,122 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newTestAccessLogger(options ...AccessLogOption) (*AccessLogger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return NewAccessLogger(append([]AccessLogOption{WithAccessLogLogger(logger)}, options...)...), buf
+}
+
+func decodeRecord(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	record := map[string]any{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	buf.Reset()
+	return record
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	logger, buf := newTestAccessLogger(
+		WithAccessLogHeaders("Authorization", "X-Trace"),
+		WithAccessLogRedactQuery("token"),
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	})
+	handler := Middleware()(logger.Middleware()(mux))
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1?token=secret&page=2", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Trace", "trace-1")
+	r.Header.Set(HeaderClientID, "client-1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	record := decodeRecord(t, buf)
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, "GET /users/{id}", record["route"])
+	assert.Equal(t, "/users/1", record["path"])
+	assert.Equal(t, "page=2&token=%5BREDACTED%5D", record["query"])
+	assert.EqualValues(t, http.StatusCreated, record["status"])
+	assert.EqualValues(t, 5, record["bytes"])
+	assert.Equal(t, "client-1", record["client_id"])
+	assert.Equal(t, map[string]any{"Authorization": redacted, "X-Trace": "trace-1"}, record["headers"])
+}
+
+func TestAccessLogMiddlewarePanic(t *testing.T) {
+	logger, buf := newTestAccessLogger()
+	handler := logger.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	assert.PanicsWithValue(t, "boom", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	record := decodeRecord(t, buf)
+	assert.Equal(t, "ERROR", record["level"])
+	assert.EqualValues(t, http.StatusInternalServerError, record["status"])
+	assert.Equal(t, "boom", record["panic"].(map[string]any)["error"])
+}
+
+func TestAccessLogUnaryServerInterceptor(t *testing.T) {
+	logger, buf := newTestAccessLogger(WithAccessLogHeaders("x-trace"))
+	interceptor := logger.UnaryServerInterceptor()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-trace", "trace-1"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUser"}
+
+	resp := wrapperspb.String("hello")
+	_, err := interceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return resp, nil
+	})
+	assert.NoError(t, err)
+	record := decodeRecord(t, buf)
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, "/user.UserService/GetUser", record["method"])
+	assert.Equal(t, "OK", record["status"])
+	assert.EqualValues(t, 7, record["bytes"])
+	assert.Equal(t, map[string]any{"x-trace": "trace-1"}, record["headers"])
+
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "INFO", decodeRecord(t, buf)["level"])
+
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, errors.New("database is down")
+	})
+	assert.Error(t, err)
+	record = decodeRecord(t, buf)
+	assert.Equal(t, "ERROR", record["level"])
+	assert.Equal(t, "Unknown", record["status"])
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	logger, buf := newTestAccessLogger(WithAccessLogSampling(0))
+	handler := logger.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.Zero(t, buf.Len())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	assert.EqualValues(t, http.StatusBadGateway, decodeRecord(t, buf)["status"])
+}
@@ -0,0 +1,125 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cro4k/toolkit/values"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const HeaderAuthorization = "authorization"
+
+// Principal is the authenticated caller, it is stored in the context next to the ClientInfo.
+type Principal struct {
+	Subject   string         `json:"sub"`
+	Issuer    string         `json:"iss,omitempty"`
+	Audience  []string       `json:"aud,omitempty"`
+	Scopes    []string       `json:"scopes,omitempty"`
+	ExpiresAt time.Time      `json:"exp,omitempty"`
+	Claims    map[string]any `json:"claims,omitempty"`
+
+	// Token is the raw token, it's propagated by the client interceptors.
+	Token string `json:"-"`
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return values.Contains(p.Scopes, scope)
+}
+
+// DefaultClaimsMapper maps the registered claims, the scopes are read from "scope" (space separated) or "scp".
+func DefaultClaimsMapper(claims map[string]any) (*Principal, error) {
+	p := &Principal{Claims: claims}
+	p.Subject, _ = claims["sub"].(string)
+	p.Issuer, _ = claims["iss"].(string)
+	p.Audience = stringOrList(claims["aud"])
+	if exp, ok := numericDate(claims, "exp"); ok {
+		p.ExpiresAt = exp
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	} else {
+		p.Scopes = stringOrList(claims["scp"])
+	}
+	return p, nil
+}
+
+type principalContextKey struct{}
+
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	if !ok {
+		return nil
+	}
+	return p
+}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+var errTokenMissing = errors.New("token is missing")
+
+func bearerToken(authorization string) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func (v *JWTVerifier) authenticate(ctx context.Context, authorization string) (context.Context, error) {
+	token := bearerToken(authorization)
+	if token == "" {
+		if v.optional {
+			return ctx, nil
+		}
+		return ctx, errTokenMissing
+	}
+	principal, err := v.Verify(ctx, token)
+	if err != nil {
+		return ctx, err
+	}
+	return WithPrincipal(ctx, principal), nil
+}
+
+// Middleware verifies the bearer token of the Authorization header, the requests without a valid token
+// are rejected with 401.
+func (v *JWTVerifier) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := v.authenticate(r.Context(), r.Header.Get(HeaderAuthorization))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (v *JWTVerifier) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := v.authenticate(ctx, defMD(incomingMD(ctx), HeaderAuthorization, ""))
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (v *JWTVerifier) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := v.authenticate(ss.Context(), defMD(incomingMD(ss.Context()), HeaderAuthorization, ""))
+		if err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
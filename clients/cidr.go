@@ -0,0 +1,141 @@
+package clients
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseCIDRs parses a list of IPv4 addresses, IPv4 CIDRs, IPv6 addresses or IPv6 CIDRs,
+// a single address is treated as a /32 or /128 network.
+func ParseCIDRs(items []string) ([]*net.IPNet, error) {
+	cidr := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := parseIP(item)
+			if ip == nil {
+				return cidr, &net.ParseError{Type: "IP address", Text: item}
+			}
+
+			switch len(ip) {
+			case net.IPv4len:
+				item += "/32"
+			case net.IPv6len:
+				item += "/128"
+			}
+		}
+		_, cidrNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return cidr, err
+		}
+		cidr = append(cidr, cidrNet)
+	}
+	return cidr, nil
+}
+
+type cidrNode[V any] struct {
+	children [2]*cidrNode[V]
+	network  *net.IPNet
+	value    V
+}
+
+// cidrTree is a binary radix tree of the networks, the lookup returns the value of the longest matched prefix.
+type cidrTree[V any] struct {
+	v4, v6 *cidrNode[V]
+	size   int
+}
+
+func (t *cidrTree[V]) root(ip net.IP) (**cidrNode[V], net.IP) {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &t.v4, ipv4
+	}
+	return &t.v6, ip.To16()
+}
+
+func (t *cidrTree[V]) insert(network *net.IPNet, value V) {
+	root, ip := t.root(network.IP)
+	ones, bits := network.Mask.Size()
+	if len(ip) == net.IPv4len && bits == 8*net.IPv6len {
+		// a v4-mapped IPv6 prefix, e.g. ::ffff:10.0.0.0/104 is 10.0.0.0/8,
+		// the shorter ones also cover the non-mapped addresses and stay in the IPv6 tree.
+		if ones >= 96 {
+			ones -= 96
+		} else {
+			root, ip = &t.v6, network.IP.To16()
+		}
+	}
+	if *root == nil {
+		*root = &cidrNode[V]{}
+	}
+	node := *root
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrNode[V]{}
+		}
+		node = node.children[bit]
+	}
+	if node.network == nil {
+		t.size++
+	}
+	node.network, node.value = network, value
+}
+
+func (t *cidrTree[V]) lookup(ip net.IP) (network *net.IPNet, value V, ok bool) {
+	if ip == nil {
+		return nil, value, false
+	}
+	root, ip := t.root(ip)
+	node := *root
+	for i := 0; node != nil; i++ {
+		if node.network != nil {
+			network, value, ok = node.network, node.value, true
+		}
+		if i == len(ip)*8 {
+			break
+		}
+		node = node.children[ip[i/8]>>(7-i%8)&1]
+	}
+	return network, value, ok
+}
+
+// CIDRSet is a set of networks with longest-prefix matching.
+type CIDRSet struct {
+	tree cidrTree[struct{}]
+}
+
+func NewCIDRSet(networks ...*net.IPNet) *CIDRSet {
+	s := &CIDRSet{}
+	for _, network := range networks {
+		s.Add(network)
+	}
+	return s
+}
+
+// ParseCIDRSet parses the items by ParseCIDRs.
+func ParseCIDRSet(items []string) (*CIDRSet, error) {
+	networks, err := ParseCIDRs(items)
+	if err != nil {
+		return nil, err
+	}
+	return NewCIDRSet(networks...), nil
+}
+
+func (s *CIDRSet) Add(network *net.IPNet) {
+	s.tree.insert(network, struct{}{})
+}
+
+func (s *CIDRSet) Contains(ip net.IP) bool {
+	_, _, ok := s.tree.lookup(ip)
+	return ok
+}
+
+// Match returns the most specific network containing the ip.
+func (s *CIDRSet) Match(ip net.IP) (*net.IPNet, bool) {
+	network, _, ok := s.tree.lookup(ip)
+	return network, ok
+}
+
+func (s *CIDRSet) Len() int {
+	return s.tree.size
+}
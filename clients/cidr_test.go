@@ -0,0 +1,42 @@
+package clients
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCIDRSet(t *testing.T) {
+	set, err := ParseCIDRSet([]string{"10.0.0.0/8", "10.1.0.0/16", "192.168.1.1", "2001:db8::/32"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, set.Len())
+
+	network, ok := set.Match(net.ParseIP("10.1.2.3"))
+	assert.True(t, ok)
+	assert.Equal(t, "10.1.0.0/16", network.String())
+	network, ok = set.Match(net.ParseIP("10.2.2.3"))
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.0/8", network.String())
+
+	assert.True(t, set.Contains(net.ParseIP("192.168.1.1")))
+	assert.False(t, set.Contains(net.ParseIP("192.168.1.2")))
+	assert.True(t, set.Contains(net.ParseIP("2001:db8::1")))
+	assert.False(t, set.Contains(net.ParseIP("2001:db9::1")))
+	assert.False(t, set.Contains(nil))
+
+	_, err = ParseCIDRSet([]string{"10.0.0.256"})
+	assert.Error(t, err)
+
+	// the v4-mapped IPv6 prefixes match the IPv4 addresses
+	set, err = ParseCIDRSet([]string{"::ffff:10.0.0.0/104", "::ffff:0:0/80"})
+	assert.NoError(t, err)
+	assert.True(t, set.Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, set.Contains(net.ParseIP("::ffff:10.1.2.3")))
+	assert.False(t, set.Contains(net.ParseIP("11.1.2.3")))
+	assert.True(t, set.Contains(net.ParseIP("::fffe:0:1")))
+	assert.False(t, set.Contains(net.ParseIP("2001:db8::1")))
+	assert.NotPanics(t, func() {
+		set.Add(&net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(64, 128)})
+	})
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"net/http"
 
-	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
@@ -15,6 +14,9 @@ const (
 	HeaderClientIP  = "x-client-ip"
 	HeaderUA        = "x-client-ua"
 	HeaderRequestID = "x-request-id"
+
+	// HeaderExtraPrefix is the prefix of the headers carrying ClientInfo.Extra, e.g. x-client-extra-locale.
+	HeaderExtraPrefix = "x-client-extra-"
 )
 
 type ClientInfo struct {
@@ -23,16 +25,31 @@ type ClientInfo struct {
 	UA        string `json:"ua"`
 	Version   string `json:"v"`
 	RequestID string `json:"rid"`
+
+	// Extra is the custom fields, such as tenant id, locale and device id, see WithExtraHeader.
+	Extra map[string]string `json:"extra,omitempty"`
+
+	// Device is parsed from UA, it's shared by the requests with the same UA and must not be modified.
+	Device *Device `json:"device,omitempty"`
+
+	// generatedID reports the ID is generated by the Extractor since the request has none.
+	generatedID bool
 }
 
+// MD returns the outgoing metadata with the default header names,
+// the Extra fields are carried by the headers prefixed with HeaderExtraPrefix.
 func (c *ClientInfo) MD() metadata.MD {
-	return metadata.New(map[string]string{
+	md := metadata.New(map[string]string{
 		HeaderClientID:  c.ID,
 		HeaderVersion:   c.Version,
 		HeaderClientIP:  c.IP,
 		HeaderUA:        c.UA,
 		HeaderRequestID: c.RequestID,
 	})
+	for key, val := range c.Extra {
+		md.Set(HeaderExtraPrefix+key, val)
+	}
+	return md
 }
 
 type clientInfoContextKey struct{}
@@ -50,91 +67,52 @@ func WithContext(ctx context.Context, info *ClientInfo) context.Context {
 }
 
 func Middleware() func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return middleware(next)
-	}
+	return defaultExtractor.Middleware()
 }
 
 func MiddlewareFunc() func(http.HandlerFunc) http.HandlerFunc {
-	return func(handler http.HandlerFunc) http.HandlerFunc {
-		return middleware(handler)
-	}
-}
-
-func middleware(next http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		info := &ClientInfo{
-			ID:        def(r.Header.Get(HeaderClientID)),
-			IP:        ClientIP(r),
-			UA:        r.UserAgent(),
-			Version:   r.Header.Get(HeaderVersion),
-			RequestID: def(r.Header.Get(HeaderRequestID)),
-		}
-		ctx := WithContext(r.Context(), info)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	}
+	return defaultExtractor.MiddlewareFunc()
 }
 
 func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		return handler(withGRPCServerContext(ctx), req)
-	}
+	return defaultExtractor.UnaryServerInterceptor()
 }
 
 type ServerStream struct {
 	grpc.ServerStream
 }
 
+// Context returns the context with the ClientInfo, the default Extractor resolves no tenant so it never fails.
 func (s *ServerStream) Context() context.Context {
-	return withGRPCServerContext(s.ServerStream.Context())
+	ctx, _ := defaultExtractor.serverContext(s.ServerStream.Context())
+	return ctx
 }
 
-func StreamServerInterceptor() grpc.StreamServerInterceptor {
-	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		return handler(srv, &ServerStream{ss})
-	}
+// contextServerStream replaces the context of the grpc.ServerStream.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
 }
 
-func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
-	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		return invoker(withGRPCClientContext(ctx), method, req, reply, cc, opts...)
-	}
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
 }
 
-func StreamClientInterceptor() grpc.StreamClientInterceptor {
-	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-		return streamer(withGRPCClientContext(ctx), desc, cc, method, opts...)
-	}
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return defaultExtractor.StreamServerInterceptor()
 }
 
-func withGRPCServerContext(ctx context.Context) context.Context {
-	if client := FromContext(ctx); client == nil {
-		md, _ := metadata.FromIncomingContext(ctx)
-		client = &ClientInfo{
-			ID:        defMD(md, HeaderClientID, uuid.New().String()),
-			IP:        defMD(md, HeaderClientIP, ""),
-			UA:        defMD(md, HeaderUA, ""),
-			Version:   defMD(md, HeaderVersion, ""),
-			RequestID: defMD(md, HeaderRequestID, uuid.New().String()),
-		}
-		return WithContext(ctx, client)
-	}
-	return ctx
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return defaultExtractor.UnaryClientInterceptor()
 }
 
-func withGRPCClientContext(ctx context.Context) context.Context {
-	client := FromContext(ctx)
-	if client != nil {
-		return metadata.NewOutgoingContext(ctx, client.MD())
-	}
-	return ctx
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return defaultExtractor.StreamClientInterceptor()
 }
 
-func def(a string) string {
-	if a != "" {
-		return a
-	}
-	return uuid.New().String()
+func incomingMD(ctx context.Context) metadata.MD {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return md
 }
 
 func defMD(md metadata.MD, key, def string) string {
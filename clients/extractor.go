@@ -0,0 +1,325 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Extractor builds the ClientInfo of the incoming requests and propagates it on the outgoing calls.
+// The package level Middleware and interceptors use the default Extractor, create another one by NewExtractor
+// to rename the headers, extract the extra fields or change the id generation.
+type Extractor struct {
+	clientIDHeader  string
+	versionHeader   string
+	clientIPHeader  string
+	uaHeader        string
+	requestIDHeader string
+
+	// extraHeaders maps the key of ClientInfo.Extra to the header name
+	extraHeaders map[string]string
+
+	idGenerator        func() string
+	requestIDGenerator func() string
+	ipResolver         *IPResolver
+
+	tenantBaseDomain string
+	tenantClaim      string
+	enforceTenant    bool
+}
+
+type ExtractorOption func(*Extractor)
+
+const (
+	maxExtraFields   = 16
+	maxExtraValueLen = 256
+)
+
+func WithClientIDHeader(name string) ExtractorOption {
+	return func(e *Extractor) {
+		e.clientIDHeader = strings.ToLower(name)
+	}
+}
+
+func WithVersionHeader(name string) ExtractorOption {
+	return func(e *Extractor) {
+		e.versionHeader = strings.ToLower(name)
+	}
+}
+
+// WithClientIPHeader renames the header carrying the client ip between services,
+// see IPResolver.ClientIPFromContext for how it is trusted.
+func WithClientIPHeader(name string) ExtractorOption {
+	return func(e *Extractor) {
+		e.clientIPHeader = strings.ToLower(name)
+	}
+}
+
+// WithUAHeader renames the metadata carrying the user agent between services,
+// the http middleware always reads the User-Agent header.
+func WithUAHeader(name string) ExtractorOption {
+	return func(e *Extractor) {
+		e.uaHeader = strings.ToLower(name)
+	}
+}
+
+func WithRequestIDHeader(name string) ExtractorOption {
+	return func(e *Extractor) {
+		e.requestIDHeader = strings.ToLower(name)
+	}
+}
+
+// WithExtraHeader extracts the header into ClientInfo.Extra[key], for example:
+//
+//	clients.WithExtraHeader("tenant", "x-tenant-id")
+//
+// The field is propagated by the same header on the outgoing calls. Only the configured headers are extracted,
+// at most 16 fields and the values longer than 256 bytes are dropped.
+func WithExtraHeader(key, header string) ExtractorOption {
+	return func(e *Extractor) {
+		e.extraHeaders[strings.ToLower(key)] = strings.ToLower(header)
+	}
+}
+
+// WithExtraKeys extracts the keys from the headers prefixed with HeaderExtraPrefix, which are the headers
+// propagating the Extra fields of an upstream service without WithExtraHeader, e.g. x-client-extra-locale.
+func WithExtraKeys(keys ...string) ExtractorOption {
+	return func(e *Extractor) {
+		for _, key := range keys {
+			key = strings.ToLower(key)
+			e.extraHeaders[key] = HeaderExtraPrefix + key
+		}
+	}
+}
+
+// WithIDGenerator set how the client id is generated when the request has none,
+// a nil generator leaves the id empty. A random uuid is generated by default.
+func WithIDGenerator(generator func() string) ExtractorOption {
+	return func(e *Extractor) {
+		e.idGenerator = generator
+	}
+}
+
+// WithRequestIDGenerator set how the request id is generated when the request has none,
+// a nil generator leaves the id empty. A random uuid is generated by default.
+func WithRequestIDGenerator(generator func() string) ExtractorOption {
+	return func(e *Extractor) {
+		e.requestIDGenerator = generator
+	}
+}
+
+// WithIPResolver replaces DefaultIPResolver.
+func WithIPResolver(resolver *IPResolver) ExtractorOption {
+	return func(e *Extractor) {
+		e.ipResolver = resolver
+	}
+}
+
+func newUUID() string {
+	return uuid.New().String()
+}
+
+func NewExtractor(options ...ExtractorOption) *Extractor {
+	e := &Extractor{
+		clientIDHeader:     HeaderClientID,
+		versionHeader:      HeaderVersion,
+		clientIPHeader:     HeaderClientIP,
+		uaHeader:           HeaderUA,
+		requestIDHeader:    HeaderRequestID,
+		extraHeaders:       map[string]string{},
+		idGenerator:        newUUID,
+		requestIDGenerator: newUUID,
+	}
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
+var defaultExtractor = NewExtractor()
+
+func (e *Extractor) resolver() *IPResolver {
+	if e.ipResolver != nil {
+		return e.ipResolver
+	}
+	return DefaultIPResolver
+}
+
+func generate(value string, generator func() string) string {
+	if value != "" || generator == nil {
+		return value
+	}
+	return generator()
+}
+
+// MD returns the outgoing metadata of the ClientInfo with the header names of the Extractor.
+func (e *Extractor) MD(c *ClientInfo) metadata.MD {
+	md := metadata.New(map[string]string{
+		e.clientIDHeader:  c.ID,
+		e.versionHeader:   c.Version,
+		e.clientIPHeader:  c.IP,
+		e.uaHeader:        c.UA,
+		e.requestIDHeader: c.RequestID,
+	})
+	for key, val := range c.Extra {
+		if header, ok := e.extraHeaders[key]; ok {
+			md.Set(header, val)
+		} else {
+			md.Set(HeaderExtraPrefix+key, val)
+		}
+	}
+	return md
+}
+
+// extra reads the configured extra headers only, the other headers are sent by the clients freely.
+func (e *Extractor) extra(get func(string) string) map[string]string {
+	extra := map[string]string{}
+	for key, header := range e.extraHeaders {
+		if len(extra) == maxExtraFields {
+			break
+		}
+		if val := get(header); val != "" && len(val) <= maxExtraValueLen {
+			extra[key] = val
+		}
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
+}
+
+// fromRequest returns the ClientInfo and the error of resolving the tenant, the ClientInfo is valid on error.
+func (e *Extractor) fromRequest(r *http.Request) (*ClientInfo, error) {
+	info := &ClientInfo{
+		ID:        generate(r.Header.Get(e.clientIDHeader), e.idGenerator),
+		IP:        e.resolver().ClientIP(r),
+		UA:        r.UserAgent(),
+		Version:   r.Header.Get(e.versionHeader),
+		RequestID: generate(r.Header.Get(e.requestIDHeader), e.requestIDGenerator),
+		Extra:     e.extra(r.Header.Get),
+	}
+	info.generatedID = info.ID != "" && r.Header.Get(e.clientIDHeader) == ""
+	err := e.resolveTenant(r.Context(), info, r.Host)
+	info.Device = ParseUserAgent(info.UA)
+	return info, err
+}
+
+func (e *Extractor) fromIncomingContext(ctx context.Context) (*ClientInfo, error) {
+	md := incomingMD(ctx)
+	info := &ClientInfo{
+		ID:        generate(defMD(md, e.clientIDHeader, ""), e.idGenerator),
+		IP:        e.resolver().clientIPFromContext(ctx, e.clientIPHeader),
+		UA:        defMD(md, e.uaHeader, ""),
+		Version:   defMD(md, e.versionHeader, ""),
+		RequestID: generate(defMD(md, e.requestIDHeader, ""), e.requestIDGenerator),
+		Extra:     e.extra(func(key string) string { return defMD(md, key, "") }),
+	}
+	info.generatedID = info.ID != "" && defMD(md, e.clientIDHeader, "") == ""
+	err := e.resolveTenant(ctx, info, defMD(md, ":authority", ""))
+	info.Device = ParseUserAgent(info.UA)
+	return info, err
+}
+
+func (e *Extractor) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return e.middleware(next)
+	}
+}
+
+func (e *Extractor) MiddlewareFunc() func(http.HandlerFunc) http.HandlerFunc {
+	return func(handler http.HandlerFunc) http.HandlerFunc {
+		return e.middleware(handler)
+	}
+}
+
+func (e *Extractor) middleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := e.fromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		ctx := withGeoContext(WithContext(r.Context(), info), info.IP)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// serverContext returns the context with the ClientInfo, the error is a status of codes.PermissionDenied.
+func (e *Extractor) serverContext(ctx context.Context) (context.Context, error) {
+	if client := FromContext(ctx); client == nil {
+		client, err := e.fromIncomingContext(ctx)
+		if err != nil {
+			return ctx, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return withGeoContext(WithContext(ctx, client), client.IP), nil
+	}
+	return ctx, nil
+}
+
+func (e *Extractor) clientContext(ctx context.Context) context.Context {
+	md := metadata.MD{}
+	if client := FromContext(ctx); client != nil {
+		md = e.MD(client)
+	}
+	if principal := PrincipalFromContext(ctx); principal != nil && principal.Token != "" {
+		md.Set(HeaderAuthorization, "Bearer "+principal.Token)
+	}
+	// the deadline is also propagated by grpc-timeout natively, the header is for the http gateways behind.
+	if remaining, ok := RemainingTimeout(ctx); ok && remaining > 0 {
+		md.Set(HeaderRequestTimeout, FormatRequestTimeout(remaining))
+	}
+	if len(md) == 0 {
+		return ctx
+	}
+	// keep the metadata set by the caller explicitly
+	if out, ok := metadata.FromOutgoingContext(ctx); ok {
+		for key, val := range out {
+			md[key] = val
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+func (e *Extractor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := e.serverContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (e *Extractor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := e.serverContext(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (e *Extractor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := e.checkOutgoingTenant(ctx); err != nil {
+			return err
+		}
+		return invoker(e.clientContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+func (e *Extractor) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := e.checkOutgoingTenant(ctx); err != nil {
+			return nil, err
+		}
+		return streamer(e.clientContext(ctx), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,55 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestExtractor(t *testing.T) {
+	extractor := NewExtractor(
+		WithClientIDHeader("X-Device-ID"),
+		WithExtraHeader("tenant", "X-Tenant-ID"),
+		WithExtraKeys("locale", "device"),
+		WithIDGenerator(nil),
+	)
+
+	var info *ClientInfo
+	handler := extractor.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info = FromContext(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Device-ID", "device-1")
+	r.Header.Set("X-Tenant-ID", "tenant-1")
+	r.Header.Set(HeaderExtraPrefix+"locale", "en-US")
+	// the headers not configured and the long values are dropped
+	r.Header.Set(HeaderExtraPrefix+"role", "admin")
+	r.Header.Set(HeaderExtraPrefix+"device", strings.Repeat("x", maxExtraValueLen+1))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "device-1", info.ID)
+	assert.NotEmpty(t, info.RequestID)
+	assert.Equal(t, map[string]string{"tenant": "tenant-1", "locale": "en-US"}, info.Extra)
+
+	// round trip through the outgoing metadata
+	md := extractor.MD(info)
+	assert.Equal(t, []string{"device-1"}, md.Get("x-device-id"))
+	assert.Equal(t, []string{"tenant-1"}, md.Get("x-tenant-id"))
+	assert.Equal(t, []string{"en-US"}, md.Get(HeaderExtraPrefix+"locale"))
+	received, err := extractor.fromIncomingContext(metadata.NewIncomingContext(context.Background(), md))
+	assert.NoError(t, err)
+	assert.Equal(t, info.ID, received.ID)
+	assert.Equal(t, info.RequestID, received.RequestID)
+	assert.Equal(t, info.Extra, received.Extra)
+
+	// the id is not generated
+	received, err = extractor.fromIncomingContext(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, received.ID)
+	assert.Nil(t, received.Extra)
+}
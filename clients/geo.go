@@ -0,0 +1,190 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Geo is the location and network information of the client IP.
+type Geo struct {
+	Country        string `json:"country,omitempty"`
+	CountryName    string `json:"country_name,omitempty"`
+	Region         string `json:"region,omitempty"`
+	RegionName     string `json:"region_name,omitempty"`
+	City           string `json:"city,omitempty"`
+	ASN            uint   `json:"asn,omitempty"`
+	ASOrganization string `json:"as_org,omitempty"`
+}
+
+type mmdbNames struct {
+	ISOCode string            `maxminddb:"iso_code"`
+	Names   map[string]string `maxminddb:"names"`
+}
+
+// mmdbRecord covers the fields of the GeoIP2/GeoLite2 Country, City and ASN databases,
+// and the other databases of the same layout (e.g. DB-IP lite).
+type mmdbRecord struct {
+	Country        mmdbNames   `maxminddb:"country"`
+	Subdivisions   []mmdbNames `maxminddb:"subdivisions"`
+	City           mmdbNames   `maxminddb:"city"`
+	ASN            uint        `maxminddb:"autonomous_system_number"`
+	ASOrganization string      `maxminddb:"autonomous_system_organization"`
+}
+
+// geoReader is implemented by *maxminddb.Reader.
+type geoReader interface {
+	Lookup(ip net.IP, result any) error
+}
+
+type geoFile struct {
+	name    string
+	modTime time.Time
+	reader  atomic.Value // geoReader
+}
+
+func (f *geoFile) load() error {
+	stat, err := os.Stat(f.name)
+	if err != nil {
+		return err
+	}
+	// the file is read into memory instead of mmap, so the old reader can be dropped safely on reloading
+	data, err := os.ReadFile(f.name)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	f.modTime = stat.ModTime()
+	f.reader.Store(geoReader(reader))
+	return nil
+}
+
+// GeoDB looks up the Geo of an IP from the local MMDB-format database files, for example a City database
+// and an ASN database, the results of the files are merged.
+type GeoDB struct {
+	files []*geoFile
+
+	// OnReloadError is called when a changed file can't be reloaded, the previous data is kept.
+	OnReloadError func(filename string, err error)
+}
+
+// OpenGeoDB loads the database files, call GeoDB.Watch to reload the files when they change.
+func OpenGeoDB(filenames ...string) (*GeoDB, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("no geo database file")
+	}
+	db := &GeoDB{}
+	for _, name := range filenames {
+		f := &geoFile{name: name}
+		if err := f.load(); err != nil {
+			return nil, err
+		}
+		db.files = append(db.files, f)
+	}
+	return db, nil
+}
+
+func (db *GeoDB) Lookup(ip string) (*Geo, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: ip}
+	}
+	geo := &Geo{}
+	for _, f := range db.files {
+		var record mmdbRecord
+		if err := f.reader.Load().(geoReader).Lookup(parsed, &record); err != nil {
+			return nil, err
+		}
+		if record.Country.ISOCode != "" {
+			geo.Country = record.Country.ISOCode
+			geo.CountryName = record.Country.Names["en"]
+		}
+		if len(record.Subdivisions) > 0 {
+			geo.Region = record.Subdivisions[0].ISOCode
+			geo.RegionName = record.Subdivisions[0].Names["en"]
+		}
+		if name := record.City.Names["en"]; name != "" {
+			geo.City = name
+		}
+		if record.ASN != 0 {
+			geo.ASN = record.ASN
+			geo.ASOrganization = record.ASOrganization
+		}
+	}
+	return geo, nil
+}
+
+// Watch checks the modification time of the files every interval and reloads the changed ones,
+// it blocks until the context is done.
+func (db *GeoDB) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			db.reload()
+		}
+	}
+}
+
+func (db *GeoDB) reload() {
+	for _, f := range db.files {
+		stat, err := os.Stat(f.name)
+		if err != nil || stat.ModTime().Equal(f.modTime) {
+			continue
+		}
+		if err = f.load(); err != nil && db.OnReloadError != nil {
+			db.OnReloadError(f.name, err)
+		}
+	}
+}
+
+type geoContextKey struct{}
+
+func GeoFromContext(ctx context.Context) *Geo {
+	geo, ok := ctx.Value(geoContextKey{}).(*Geo)
+	if !ok {
+		return nil
+	}
+	return geo
+}
+
+func WithGeo(ctx context.Context, geo *Geo) context.Context {
+	return context.WithValue(ctx, geoContextKey{}, geo)
+}
+
+var defaultGeoDB atomic.Pointer[GeoDB]
+
+// SetGeoDB enables the geo enrichment of Middleware and the server interceptors, the Geo of ClientInfo.IP
+// can be got by GeoFromContext, the private and loopback IPs are skipped. Set nil to disable it.
+func SetGeoDB(db *GeoDB) {
+	defaultGeoDB.Store(db)
+}
+
+// publicIP reports whether the ip can be found in the geo databases.
+func publicIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && !parsed.IsPrivate() && !parsed.IsLoopback() && !parsed.IsLinkLocalUnicast() && !parsed.IsUnspecified()
+}
+
+func withGeoContext(ctx context.Context, ip string) context.Context {
+	db := defaultGeoDB.Load()
+	if db == nil || !publicIP(ip) || GeoFromContext(ctx) != nil {
+		return ctx
+	}
+	geo, err := db.Lookup(ip)
+	if err != nil {
+		return ctx
+	}
+	return WithGeo(ctx, geo)
+}
@@ -0,0 +1,110 @@
+package clients
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGeoReader returns the record of the first network containing the ip.
+type fakeGeoReader struct {
+	networks map[string]mmdbRecord
+	lookups  int
+}
+
+func (r *fakeGeoReader) Lookup(ip net.IP, result any) error {
+	r.lookups++
+	for cidr, record := range r.networks {
+		if _, network, _ := net.ParseCIDR(cidr); network.Contains(ip) {
+			*result.(*mmdbRecord) = record
+		}
+	}
+	return nil
+}
+
+func newFakeGeoDB(readers ...*fakeGeoReader) *GeoDB {
+	db := &GeoDB{}
+	for _, reader := range readers {
+		f := &geoFile{}
+		f.reader.Store(geoReader(reader))
+		db.files = append(db.files, f)
+	}
+	return db
+}
+
+func TestGeoDB(t *testing.T) {
+	city := &fakeGeoReader{networks: map[string]mmdbRecord{
+		"81.2.69.0/24": {
+			Country:      mmdbNames{ISOCode: "GB", Names: map[string]string{"en": "United Kingdom"}},
+			Subdivisions: []mmdbNames{{ISOCode: "ENG", Names: map[string]string{"en": "England"}}},
+			City:         mmdbNames{Names: map[string]string{"en": "London"}},
+		},
+	}}
+	asn := &fakeGeoReader{networks: map[string]mmdbRecord{
+		"81.2.0.0/16": {ASN: 20712, ASOrganization: "Andrews & Arnold Ltd"},
+	}}
+	db := newFakeGeoDB(city, asn)
+
+	geo, err := db.Lookup("81.2.69.160")
+	assert.NoError(t, err)
+	assert.Equal(t, &Geo{
+		Country:        "GB",
+		CountryName:    "United Kingdom",
+		Region:         "ENG",
+		RegionName:     "England",
+		City:           "London",
+		ASN:            20712,
+		ASOrganization: "Andrews & Arnold Ltd",
+	}, geo)
+
+	geo, err = db.Lookup("81.2.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Geo{ASN: 20712, ASOrganization: "Andrews & Arnold Ltd"}, geo)
+
+	_, err = db.Lookup("unknown")
+	assert.Error(t, err)
+
+	_, err = OpenGeoDB()
+	assert.Error(t, err)
+	_, err = OpenGeoDB(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+}
+
+func TestGeoMiddleware(t *testing.T) {
+	reader := &fakeGeoReader{networks: map[string]mmdbRecord{
+		"81.2.69.0/24": {Country: mmdbNames{ISOCode: "GB"}},
+	}}
+	serve := func(remoteAddr string) *Geo {
+		var geo *Geo
+		handler := Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			geo = GeoFromContext(r.Context())
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		return geo
+	}
+
+	// no database
+	SetGeoDB(nil)
+	assert.Nil(t, serve("81.2.69.160:1234"))
+
+	SetGeoDB(newFakeGeoDB(reader))
+	defer SetGeoDB(nil)
+	assert.Equal(t, &Geo{Country: "GB"}, serve("81.2.69.160:1234"))
+	assert.Equal(t, 1, reader.lookups)
+
+	// the private and loopback ips are not looked up
+	assert.Nil(t, serve("10.0.0.1:1234"))
+	assert.Nil(t, serve("127.0.0.1:1234"))
+	assert.Equal(t, 1, reader.lookups)
+
+	// the geo in the context is kept
+	ctx := WithGeo(context.Background(), &Geo{Country: "US"})
+	assert.Equal(t, "US", GeoFromContext(withGeoContext(ctx, "81.2.69.160")).Country)
+}
@@ -0,0 +1,306 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cro4k/toolkit/cache"
+	"github.com/cro4k/toolkit/values"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const HeaderIdempotencyKey = "idempotency-key"
+
+const (
+	defaultIdempotencyTTL     = 24 * time.Hour
+	defaultIdempotencyLockTTL = time.Minute
+	defaultIdempotencyPrefix  = "IDEMPOTENCY:"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key is reused with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("the request of the idempotency key is in progress")
+	ErrIdempotencyScopeMissing  = errors.New("idempotency key requires a client id or an authenticated principal")
+)
+
+// idempotencyRecord is the stored response of the first request.
+type idempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	// Message is the full name of the gRPC response message.
+	Message string `json:"message,omitempty"`
+}
+
+// Idempotency replays the stored response of the first request for the retries with the same Idempotency-Key,
+// the key is scoped by the subject of the Principal, the unauthenticated requests are scoped by ClientInfo.ID sent by
+// the client, so it should be used after the JWTVerifier and clients.Middleware or the clients server interceptors.
+// The requests without either are rejected with 400, since the generated id differs on each retry.
+// A key reused with a different request (method, path, query and body) is rejected with 422,
+// a retry arriving while the first request is in progress is rejected with 409.
+// Only the successful responses (status < 500 or a nil error) are stored, the failed ones can be retried.
+type Idempotency struct {
+	cache   cache.Cache
+	objects cache.ObjectCache
+	ttl     time.Duration
+	lockTTL time.Duration
+	methods []string
+	maxBody int64
+}
+
+type IdempotencyOption func(*Idempotency)
+
+// WithIdempotencyTTL set how long the response is stored, 24 hours by default.
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(i *Idempotency) {
+		i.ttl = ttl
+	}
+}
+
+// WithIdempotencyLockTTL set the expiration of the lock held by the first request, it should be longer
+// than the handler takes, one minute by default.
+func WithIdempotencyLockTTL(ttl time.Duration) IdempotencyOption {
+	return func(i *Idempotency) {
+		i.lockTTL = ttl
+	}
+}
+
+// WithIdempotencyMethods set the http methods the middleware applies to, POST and PATCH by default.
+func WithIdempotencyMethods(methods ...string) IdempotencyOption {
+	return func(i *Idempotency) {
+		i.methods = methods
+	}
+}
+
+// WithIdempotencyMaxBody limits the size of the request body read for the fingerprint, 1MB by default,
+// the requests with a larger body are passed to the handler directly.
+func WithIdempotencyMaxBody(n int64) IdempotencyOption {
+	return func(i *Idempotency) {
+		i.maxBody = n
+	}
+}
+
+// NewIdempotency creates Idempotency with the cache, the keys are prefixed with "IDEMPOTENCY:",
+// use cache.With(c, cache.WithPrefix(...)) to add another namespace.
+func NewIdempotency(c cache.Cache, options ...IdempotencyOption) *Idempotency {
+	i := &Idempotency{
+		ttl:     defaultIdempotencyTTL,
+		lockTTL: defaultIdempotencyLockTTL,
+		methods: []string{http.MethodPost, http.MethodPatch},
+		maxBody: 1 << 20,
+	}
+	for _, option := range options {
+		option(i)
+	}
+	i.cache = cache.With(c, cache.WithPrefix(defaultIdempotencyPrefix))
+	i.objects = cache.JSONCache(i.cache)
+	return i
+}
+
+// scope prefixes the key by the authenticated subject, or the client id of the unauthenticated requests,
+// the ids are escaped to keep the prefixes unambiguous.
+func (i *Idempotency) scope(ctx context.Context, key string) (string, error) {
+	if principal := PrincipalFromContext(ctx); principal != nil && principal.Subject != "" {
+		return "sub:" + url.QueryEscape(principal.Subject) + ":" + key, nil
+	}
+	if info := FromContext(ctx); info != nil && info.ID != "" && !info.generatedID {
+		return "client:" + url.QueryEscape(info.ID) + ":" + key, nil
+	}
+	return "", ErrIdempotencyScopeMissing
+}
+
+// begin returns the stored record if the request has been done, or acquires the lock of the key.
+func (i *Idempotency) begin(ctx context.Context, key, fingerprint string) (*idempotencyRecord, error) {
+	record := &idempotencyRecord{}
+	err := i.objects.Get(ctx, key, record)
+	if err == nil {
+		if record.Fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		}
+		return record, nil
+	}
+	if !errors.Is(err, cache.ErrCacheNotFound) {
+		return nil, err
+	}
+	locked, err := cache.SetNX(ctx, i.cache, key+":LOCK", []byte(fingerprint), i.lockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		holder, err := i.cache.Get(ctx, key+":LOCK")
+		if err == nil && string(holder) != fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		}
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return nil, nil
+}
+
+func (i *Idempotency) end(ctx context.Context, key string, record *idempotencyRecord) {
+	// the context may be canceled when the handler returns
+	ctx = context.WithoutCancel(ctx)
+	if record != nil {
+		_ = i.objects.Set(ctx, key, record, i.ttl)
+	}
+	_ = i.cache.Del(ctx, key+":LOCK")
+}
+
+func fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type idempotencyRecorder struct {
+	*ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.body.Write(b[:n])
+	return n, err
+}
+
+func (i *Idempotency) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := strings.TrimSpace(r.Header.Get(HeaderIdempotencyKey))
+			if idempotencyKey == "" || !values.Contains(i.methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			key, err := i.scope(ctx, idempotencyKey)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var body []byte
+			if r.Body != nil {
+				body, err = io.ReadAll(io.LimitReader(r.Body, i.maxBody+1))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+				if int64(len(body)) > i.maxBody {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			sum := fingerprint([]byte(r.Method), []byte(r.URL.Path), []byte(r.URL.RawQuery), body)
+			record, err := i.begin(ctx, key, sum)
+			switch {
+			case errors.Is(err, ErrIdempotencyKeyReused):
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			case errors.Is(err, ErrIdempotencyKeyInProgress):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			case record != nil:
+				for k, v := range record.Header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.Status)
+				_, _ = w.Write(record.Body)
+				return
+			}
+
+			rec := &idempotencyRecorder{ResponseWriter: NewResponseWriter(w)}
+			var stored *idempotencyRecord
+			defer func() { i.end(ctx, key, stored) }()
+			next.ServeHTTP(rec, r)
+			if rec.Status() < http.StatusInternalServerError {
+				stored = &idempotencyRecord{
+					Fingerprint: sum,
+					Status:      rec.Status(),
+					Header:      rec.Header().Clone(),
+					Body:        rec.body.Bytes(),
+				}
+			}
+		})
+	}
+}
+
+// UnaryServerInterceptor reads the Idempotency-Key from the incoming metadata, the fingerprint is computed
+// by the full method and the deterministic encoding of the request message.
+// A reused key or a missing scope is rejected with codes.InvalidArgument and a key in progress with codes.Aborted.
+func (i *Idempotency) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		idempotencyKey := strings.TrimSpace(defMD(incomingMD(ctx), HeaderIdempotencyKey, ""))
+		message, ok := req.(proto.Message)
+		if idempotencyKey == "" || !ok {
+			return handler(ctx, req)
+		}
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+		if err != nil {
+			return handler(ctx, req)
+		}
+
+		key, err := i.scope(ctx, idempotencyKey)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		sum := fingerprint([]byte(info.FullMethod), data)
+		record, err := i.begin(ctx, key, sum)
+		switch {
+		case errors.Is(err, ErrIdempotencyKeyReused):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, ErrIdempotencyKeyInProgress):
+			return nil, status.Error(codes.Aborted, err.Error())
+		case err != nil:
+			return nil, status.Error(codes.Internal, err.Error())
+		case record != nil:
+			return replayMessage(record)
+		}
+
+		var stored *idempotencyRecord
+		defer func() { i.end(ctx, key, stored) }()
+		resp, err := handler(ctx, req)
+		if m, ok := resp.(proto.Message); ok && err == nil {
+			if body, merr := proto.Marshal(m); merr == nil {
+				stored = &idempotencyRecord{
+					Fingerprint: sum,
+					Body:        body,
+					Message:     string(m.ProtoReflect().Descriptor().FullName()),
+				}
+			}
+		}
+		return resp, err
+	}
+}
+
+func replayMessage(record *idempotencyRecord) (any, error) {
+	typ, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(record.Message))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	m := typ.New().Interface()
+	if err = proto.Unmarshal(record.Body, m); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return m, nil
+}
@@ -0,0 +1,81 @@
+package clients
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cro4k/toolkit/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	var calls int
+	handler := Middleware()(NewIdempotency(cache.NewLocalCache(100)).Middleware()(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("X-Order", "1")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("created"))
+		}),
+	))
+
+	do := func(clientID, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		if clientID != "" {
+			r.Header.Set(HeaderClientID, clientID)
+		}
+		r.Header.Set(HeaderIdempotencyKey, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("client-1", "key-1", `{"item":1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, calls)
+
+	w = do("client-1", "key-1", `{"item":1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "created", w.Body.String())
+	assert.Equal(t, "1", w.Header().Get("X-Order"))
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, calls)
+
+	w = do("client-1", "key-1", `{"item":2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, calls)
+
+	// the key is scoped by the client id
+	w = do("client-2", "key-1", `{"item":2}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, calls)
+
+	// the generated client id is not a stable scope
+	w = do("", "key-2", `{"item":3}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 2, calls)
+
+	// the authenticated requests without a client id are scoped by the subject
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"item":3}`))
+		r = r.WithContext(WithPrincipal(r.Context(), &Principal{Subject: "user-1"}))
+		r.Header.Set(HeaderIdempotencyKey, "key-2")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+	assert.Equal(t, 3, calls)
+
+	// the authenticated requests are scoped by the subject even with the client id of another client
+	r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"item":1}`))
+	r = r.WithContext(WithPrincipal(r.Context(), &Principal{Subject: "user-2"}))
+	r.Header.Set(HeaderClientID, "client-1")
+	r.Header.Set(HeaderIdempotencyKey, "key-1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 4, calls)
+}
@@ -56,12 +56,7 @@ func (c *IPResolver) isTrustedProxy(ip net.IP) bool {
 	if c.trustedCIDRs == nil {
 		return false
 	}
-	for _, cidr := range c.trustedCIDRs {
-		if cidr.Contains(ip) {
-			return true
-		}
-	}
-	return false
+	return c.trustedCIDRs.Contains(ip)
 }
 
 // validateHeader will parse X-Forwarded-For header and return the trusted client IP address
@@ -106,33 +101,11 @@ func (c *IPResolver) parseTrustedProxies() error {
 	return err
 }
 
-func (c *IPResolver) prepareTrustedCIDRs() ([]*net.IPNet, error) {
+func (c *IPResolver) prepareTrustedCIDRs() (*CIDRSet, error) {
 	if c.trustedProxies == nil {
 		return nil, nil
 	}
-
-	cidr := make([]*net.IPNet, 0, len(c.trustedProxies))
-	for _, trustedProxy := range c.trustedProxies {
-		if !strings.Contains(trustedProxy, "/") {
-			ip := parseIP(trustedProxy)
-			if ip == nil {
-				return cidr, &net.ParseError{Type: "IP address", Text: trustedProxy}
-			}
-
-			switch len(ip) {
-			case net.IPv4len:
-				trustedProxy += "/32"
-			case net.IPv6len:
-				trustedProxy += "/128"
-			}
-		}
-		_, cidrNet, err := net.ParseCIDR(trustedProxy)
-		if err != nil {
-			return cidr, err
-		}
-		cidr = append(cidr, cidrNet)
-	}
-	return cidr, nil
+	return ParseCIDRSet(c.trustedProxies)
 }
 
 // parseIP parse a string representation of an IP and returns a net.IP with the
@@ -171,7 +144,7 @@ type IPResolver struct {
 	// network origins of list defined by `(*gin.Engine).SetTrustedProxies()`.
 	RemoteIPHeaders []string
 
-	trustedCIDRs   []*net.IPNet
+	trustedCIDRs   *CIDRSet
 	trustedProxies []string
 }
 
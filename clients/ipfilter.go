@@ -0,0 +1,182 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cro4k/toolkit/configuration"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IPRule is the allow/deny lists of a route. When the ip is contained by both lists, the most specific
+// network decides, for example "deny 10.0.0.0/8, allow 10.1.0.0/16" allows 10.1.2.3 and denies 10.2.3.4.
+// If the ip is in neither list, it is denied when the allow list is not empty, otherwise it is allowed.
+type IPRule struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+}
+
+// IPFilterConfig is the config of IPFilter, the key of Routes is a path prefix of http (e.g. /admin/)
+// or a full method prefix of gRPC (e.g. /admin.AdminService/), the longest matched route is applied,
+// and Default is applied when no route matches. The routes are matched on the "/" boundaries of the cleaned path,
+// so /admin matches /admin and /admin/users but not /administrator.
+type IPFilterConfig struct {
+	Default IPRule            `json:"default" yaml:"default"`
+	Routes  map[string]IPRule `json:"routes" yaml:"routes"`
+}
+
+type ipRule struct {
+	tree        cidrTree[bool]
+	allowedOnly bool
+}
+
+func (r *ipRule) allowed(ip net.IP) bool {
+	if _, allow, ok := r.tree.lookup(ip); ok {
+		return allow
+	}
+	return !r.allowedOnly
+}
+
+type ipFilterRules struct {
+	def    *ipRule
+	routes map[string]*ipRule
+}
+
+func newIPRule(rule IPRule) (*ipRule, error) {
+	r := &ipRule{allowedOnly: len(rule.Allow) > 0}
+	deny, err := ParseCIDRs(rule.Deny)
+	if err != nil {
+		return nil, err
+	}
+	allow, err := ParseCIDRs(rule.Allow)
+	if err != nil {
+		return nil, err
+	}
+	for _, network := range deny {
+		r.tree.insert(network, false)
+	}
+	// allow wins if the same network is in both lists
+	for _, network := range allow {
+		r.tree.insert(network, true)
+	}
+	return r, nil
+}
+
+// IPFilter enforces the allow/deny lists on the client ip, it should be used after clients.Middleware
+// or the clients server interceptors so the ip is resolved with the trusted proxies.
+type IPFilter struct {
+	rules atomic.Pointer[ipFilterRules]
+}
+
+func NewIPFilter(config *IPFilterConfig) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Update(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update replaces the rules, the old rules are kept if the config is invalid.
+func (f *IPFilter) Update(config *IPFilterConfig) error {
+	def, err := newIPRule(config.Default)
+	if err != nil {
+		return fmt.Errorf("invalid default ip rule: %w", err)
+	}
+	rules := &ipFilterRules{def: def, routes: map[string]*ipRule{}}
+	for route, rule := range config.Routes {
+		if rules.routes[cleanRoute(route)], err = newIPRule(rule); err != nil {
+			return fmt.Errorf("invalid ip rule of route %s: %w", route, err)
+		}
+	}
+	f.rules.Store(rules)
+	return nil
+}
+
+// Watch reloads the config from the configuration driver when it changes, see configuration.WatchWithDriver.
+func (f *IPFilter) Watch(ctx context.Context, driver configuration.Driver, key string, interval time.Duration,
+	onError func(error),
+) error {
+	return configuration.WatchWithDriver(ctx, driver, key, interval, f.Update, onError)
+}
+
+// Allowed reports whether the ip is allowed to access the route, an invalid ip is never allowed.
+func (f *IPFilter) Allowed(route, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	route = cleanRoute(route)
+	rules := f.rules.Load()
+	rule, matched := rules.def, -1
+	for prefix, r := range rules.routes {
+		if routeMatch(route, prefix) && len(prefix) > matched {
+			rule, matched = r, len(prefix)
+		}
+	}
+	return rule.allowed(parsed)
+}
+
+// cleanRoute cleans the path and trims the trailing slash, so /x/../admin/ is the same as /admin.
+func cleanRoute(route string) string {
+	return path.Clean("/" + route)
+}
+
+func routeMatch(route, prefix string) bool {
+	if prefix == "/" || route == prefix {
+		return true
+	}
+	return strings.HasPrefix(route, prefix) && route[len(prefix)] == '/'
+}
+
+func (f *IPFilter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r)
+			if info := FromContext(r.Context()); info != nil {
+				ip = info.IP
+			}
+			if !f.Allowed(r.URL.Path, ip) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (f *IPFilter) check(ctx context.Context, method string) error {
+	ip := ClientIPFromContext(ctx)
+	if info := FromContext(ctx); info != nil {
+		ip = info.IP
+	}
+	if !f.Allowed(method, ip) {
+		return status.Error(codes.PermissionDenied, "ip is not allowed")
+	}
+	return nil
+}
+
+func (f *IPFilter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := f.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (f *IPFilter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := f.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
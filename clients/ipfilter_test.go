@@ -0,0 +1,36 @@
+package clients
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := NewIPFilter(&IPFilterConfig{
+		Default: IPRule{Deny: []string{"1.2.3.4"}},
+		Routes: map[string]IPRule{
+			"/admin/":       {Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.9.0.0/16"}},
+			"/admin/public": {},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, filter.Allowed("/orders", "5.6.7.8"))
+	assert.False(t, filter.Allowed("/orders", "1.2.3.4"))
+	assert.True(t, filter.Allowed("/admin/users", "10.1.1.1"))
+	assert.False(t, filter.Allowed("/admin/users", "10.9.1.1"))
+	assert.False(t, filter.Allowed("/admin/users", "5.6.7.8"))
+	assert.True(t, filter.Allowed("/admin/public/info", "5.6.7.8"))
+	assert.False(t, filter.Allowed("/orders", "invalid"))
+
+	// the routes are matched on the boundaries of the cleaned path
+	assert.False(t, filter.Allowed("/admin", "5.6.7.8"))
+	assert.True(t, filter.Allowed("/administrator", "5.6.7.8"))
+	assert.False(t, filter.Allowed("/x/../admin/users", "5.6.7.8"))
+	assert.False(t, filter.Allowed("//admin/./users", "5.6.7.8"))
+	assert.False(t, filter.Allowed("/admin/publicity", "5.6.7.8"))
+
+	assert.Error(t, filter.Update(&IPFilterConfig{Default: IPRule{Allow: []string{"invalid"}}}))
+	assert.True(t, filter.Allowed("/admin/users", "10.1.1.1"))
+}
@@ -0,0 +1,253 @@
+package clients
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval    = time.Hour
+	defaultJWKSMinRefreshInterval = time.Minute
+	defaultJWKSLoadTimeout        = 10 * time.Second
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses the JSON Web Key Set (RFC 7517), the keys which are not used for signature
+// or of unsupported types are ignored.
+func ParseJWKS(data []byte) (StaticKeySet, error) {
+	var set struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := StaticKeySet{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.key()
+		if err != nil {
+			return nil, fmt.Errorf("parse jwk %q failed: %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k *jsonWebKey) key() (any, error) {
+	switch k.Kty {
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec point")
+		}
+		return pub, nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// JWKS is a KeySet loaded from a file or an url, the keys are cached and refreshed periodically,
+// an unknown kid also triggers a refresh (at most once a minute) to pick up the rotated keys.
+// The stale keys are served while a periodic refresh is in flight, and the concurrent refreshes
+// are merged into one load.
+type JWKS struct {
+	load            func(ctx context.Context) ([]byte, error)
+	refreshInterval time.Duration
+	loadTimeout     time.Duration
+
+	mu        sync.Mutex
+	keys      StaticKeySet
+	fetchedAt time.Time
+	call      *jwksCall
+}
+
+type jwksCall struct {
+	done chan struct{}
+	err  error
+}
+
+func (c *jwksCall) wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type JWKSOption func(*JWKS)
+
+func WithJWKSRefreshInterval(interval time.Duration) JWKSOption {
+	return func(j *JWKS) {
+		j.refreshInterval = interval
+	}
+}
+
+// WithJWKSLoadTimeout set the timeout of loading the keys, it's 10 seconds by default,
+// so a hung endpoint can't block the refreshes forever.
+func WithJWKSLoadTimeout(timeout time.Duration) JWKSOption {
+	return func(j *JWKS) {
+		j.loadTimeout = timeout
+	}
+}
+
+func NewJWKS(load func(ctx context.Context) ([]byte, error), options ...JWKSOption) *JWKS {
+	j := &JWKS{load: load, refreshInterval: defaultJWKSRefreshInterval, loadTimeout: defaultJWKSLoadTimeout}
+	for _, option := range options {
+		option(j)
+	}
+	return j
+}
+
+func NewJWKSFromFile(filename string, options ...JWKSOption) *JWKS {
+	return NewJWKS(func(context.Context) ([]byte, error) {
+		return os.ReadFile(filename)
+	}, options...)
+}
+
+func NewJWKSFromURL(url string, client *http.Client, options ...JWKSOption) *JWKS {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return NewJWKS(func(ctx context.Context) ([]byte, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, errors.New(response.Status)
+		}
+		return io.ReadAll(response.Body)
+	}, options...)
+}
+
+func (j *JWKS) snapshot() (StaticKeySet, time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.keys, j.fetchedAt
+}
+
+func (j *JWKS) Keys(ctx context.Context, kid string) ([]any, error) {
+	keys, fetchedAt := j.snapshot()
+	if keys == nil {
+		if err := j.fetch(ctx).wait(ctx); err != nil {
+			return nil, err
+		}
+		keys, fetchedAt = j.snapshot()
+	} else if time.Since(fetchedAt) >= j.refreshInterval {
+		j.fetch(ctx)
+	}
+	found, _ := keys.Keys(ctx, kid)
+	if len(found) == 0 && kid != "" && time.Since(fetchedAt) >= defaultJWKSMinRefreshInterval {
+		if err := j.fetch(ctx).wait(ctx); err != nil {
+			return nil, err
+		}
+		keys, _ = j.snapshot()
+		found, _ = keys.Keys(ctx, kid)
+	}
+	return found, nil
+}
+
+// Refresh reloads the keys immediately, or waits for the refresh in flight.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	return j.fetch(ctx).wait(ctx)
+}
+
+// fetch starts a refresh unless one is in flight, the load is not bound to the cancellation of ctx
+// since its result is shared by all the callers, it's bound to the load timeout instead.
+func (j *JWKS) fetch(ctx context.Context) *jwksCall {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.call != nil {
+		return j.call
+	}
+	call := &jwksCall{done: make(chan struct{})}
+	j.call = call
+	// the keys are kept on failure, and the next try is delayed by fetchedAt
+	j.fetchedAt = time.Now()
+	go func() {
+		var keys StaticKeySet
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), j.loadTimeout)
+		data, err := j.load(loadCtx)
+		cancel()
+		if err == nil {
+			keys, err = ParseJWKS(data)
+		}
+		j.mu.Lock()
+		if err == nil {
+			j.keys = keys
+		}
+		j.call = nil
+		call.err = err
+		j.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
@@ -0,0 +1,303 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cro4k/toolkit/values"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenUnverifiable     = errors.New("token is unverifiable")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenNoExpiration     = errors.New("token has no expiration")
+	ErrTokenNotValidYet      = errors.New("token is not valid yet")
+	ErrTokenInvalidIssuer    = errors.New("token has invalid issuer")
+	ErrTokenInvalidAudience  = errors.New("token has invalid audience")
+)
+
+// KeySet provides the keys to verify the token signature, the key must be []byte for HS256,
+// *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+type KeySet interface {
+	// Keys returns the candidate keys of the kid, kid is empty if the token header has no kid.
+	Keys(ctx context.Context, kid string) ([]any, error)
+}
+
+// StaticKeySet is a KeySet of fixed keys, the map key is the kid. A token without kid is verified by all the keys,
+// the key of the empty kid is the default key of the tokens whose kid is unknown.
+type StaticKeySet map[string]any
+
+func (s StaticKeySet) Keys(_ context.Context, kid string) ([]any, error) {
+	if kid != "" {
+		if key, ok := s[kid]; ok {
+			return []any{key}, nil
+		}
+		if key, ok := s[""]; ok {
+			return []any{key}, nil
+		}
+		return nil, nil
+	}
+	keys := make([]any, 0, len(s))
+	for _, key := range s {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// HMACKeySet returns a KeySet with the single HS256 secret, which verifies the tokens of any kid.
+func HMACKeySet(secret []byte) StaticKeySet {
+	return StaticKeySet{"": secret}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// JWTVerifier verifies the JWT and maps its claims into a Principal.
+type JWTVerifier struct {
+	keys       KeySet
+	algorithms []string
+	issuer     string
+	audience   string
+	leeway     time.Duration
+	mapper     func(claims map[string]any) (*Principal, error)
+	optional   bool
+	noExp      bool
+	now        func() time.Time
+}
+
+type JWTOption func(*JWTVerifier)
+
+// WithJWTAlgorithms restrict the accepted algorithms, HS256, RS256 and ES256 are accepted by default.
+func WithJWTAlgorithms(algorithms ...string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.algorithms = algorithms
+	}
+}
+
+// WithJWTIssuer requires the iss claim to be equal to issuer.
+func WithJWTIssuer(issuer string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithJWTAudience requires the aud claim to contain audience.
+func WithJWTAudience(audience string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.audience = audience
+	}
+}
+
+// WithJWTLeeway set the tolerance of the clock skew when checking exp and nbf.
+func WithJWTLeeway(leeway time.Duration) JWTOption {
+	return func(v *JWTVerifier) {
+		v.leeway = leeway
+	}
+}
+
+// WithJWTClaimsMapper replaces DefaultClaimsMapper.
+func WithJWTClaimsMapper(mapper func(claims map[string]any) (*Principal, error)) JWTOption {
+	return func(v *JWTVerifier) {
+		v.mapper = mapper
+	}
+}
+
+// WithJWTOptional make the middleware and interceptors let the requests without token through,
+// the requests with an invalid token are still rejected.
+func WithJWTOptional() JWTOption {
+	return func(v *JWTVerifier) {
+		v.optional = true
+	}
+}
+
+// WithJWTExpirationOptional accepts the tokens without exp claim, which never expire,
+// by default they are rejected with ErrTokenNoExpiration.
+func WithJWTExpirationOptional() JWTOption {
+	return func(v *JWTVerifier) {
+		v.noExp = true
+	}
+}
+
+func NewJWTVerifier(keys KeySet, options ...JWTOption) *JWTVerifier {
+	v := &JWTVerifier{
+		keys:       keys,
+		algorithms: []string{HS256, RS256, ES256},
+		mapper:     DefaultClaimsMapper,
+		now:        time.Now,
+	}
+	for _, option := range options {
+		option(v)
+	}
+	return v
+}
+
+// Verify checks the signature and the registered claims of the token, and maps the claims into a Principal.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if !values.Contains(v.algorithms, header.Alg) {
+		return nil, fmt.Errorf("%w: algorithm %q is not allowed", ErrTokenUnverifiable, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	keys, err := v.keys.Keys(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenUnverifiable, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: key %q not found", ErrTokenUnverifiable, header.Kid)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifySignature(header.Alg, key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrTokenSignatureInvalid
+	}
+
+	var claims map[string]any
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err = v.validate(claims); err != nil {
+		return nil, err
+	}
+	principal, err := v.mapper(claims)
+	if err != nil {
+		return nil, err
+	}
+	principal.Token = token
+	return principal, nil
+}
+
+func (v *JWTVerifier) validate(claims map[string]any) error {
+	now := v.now()
+	exp, ok := numericDate(claims, "exp")
+	switch {
+	case !ok && !v.noExp:
+		return ErrTokenNoExpiration
+	case ok && !now.Before(exp.Add(v.leeway)):
+		return ErrTokenExpired
+	}
+	if nbf, ok := numericDate(claims, "nbf"); ok && now.Add(v.leeway).Before(nbf) {
+		return ErrTokenNotValidYet
+	}
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return ErrTokenInvalidIssuer
+		}
+	}
+	if v.audience != "" && !values.Contains(stringOrList(claims["aud"]), v.audience) {
+		return ErrTokenInvalidAudience
+	}
+	return nil
+}
+
+func verifySignature(alg string, key any, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, dst any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(dst); err != nil {
+		return ErrTokenMalformed
+	}
+	return nil
+}
+
+func numericDate(claims map[string]any, key string) (time.Time, bool) {
+	n, ok := claims[key].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*float64(time.Second))), true
+}
+
+func stringOrList(v any) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []any:
+		list := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
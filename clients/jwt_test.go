@@ -0,0 +1,179 @@
+package clients
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signToken(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case RS256:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		assert.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	verifier := NewJWTVerifier(StaticKeySet{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+	}, WithJWTIssuer("toolkit"), WithJWTAudience("api"))
+
+	claims := map[string]any{
+		"sub":   "user-1",
+		"iss":   "toolkit",
+		"aud":   []string{"api", "web"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "read write",
+	}
+	for kid, key := range map[string]any{HS256: secret, RS256: rsaKey, ES256: ecKey} {
+		token := signToken(t, kid, map[string]string{HS256: "hs", RS256: "rs", ES256: "es"}[kid], key, claims)
+		principal, err := verifier.Verify(ctx, token)
+		assert.NoError(t, err, kid)
+		assert.Equal(t, "user-1", principal.Subject)
+		assert.True(t, principal.HasScope("write"))
+		assert.Equal(t, token, principal.Token)
+	}
+
+	// the hmac secret can't be used to verify a token signed with another key
+	token := signToken(t, HS256, "hs", []byte("another"), claims)
+	_, err := verifier.Verify(ctx, token)
+	assert.ErrorIs(t, err, ErrTokenSignatureInvalid)
+
+	// the rsa public key can't be used as a hmac secret
+	token = signToken(t, HS256, "rs", []byte(fmt.Sprint(rsaKey.PublicKey)), claims)
+	_, err = verifier.Verify(ctx, token)
+	assert.ErrorIs(t, err, ErrTokenSignatureInvalid)
+
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	_, err = verifier.Verify(ctx, signToken(t, HS256, "hs", secret, claims))
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	// the tokens without exp are rejected unless it's optional
+	delete(claims, "exp")
+	_, err = verifier.Verify(ctx, signToken(t, HS256, "hs", secret, claims))
+	assert.ErrorIs(t, err, ErrTokenNoExpiration)
+	_, err = NewJWTVerifier(HMACKeySet(secret), WithJWTExpirationOptional()).Verify(ctx, signToken(t, HS256, "", secret, claims))
+	assert.NoError(t, err)
+
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	claims["aud"] = "web"
+	_, err = verifier.Verify(ctx, signToken(t, HS256, "hs", secret, claims))
+	assert.ErrorIs(t, err, ErrTokenInvalidAudience)
+}
+
+func TestHMACKeySet(t *testing.T) {
+	secret := []byte("secret")
+	verifier := NewJWTVerifier(HMACKeySet(secret))
+	claims := map[string]any{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()}
+	for _, kid := range []string{"", "hs", "2024-01"} {
+		principal, err := verifier.Verify(context.Background(), signToken(t, HS256, kid, secret, claims))
+		assert.NoError(t, err, kid)
+		assert.Equal(t, "user-1", principal.Subject)
+	}
+	_, err := verifier.Verify(context.Background(), signToken(t, HS256, "hs", []byte("another"), claims))
+	assert.ErrorIs(t, err, ErrTokenSignatureInvalid)
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	enc := base64.RawURLEncoding.EncodeToString
+	data, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": enc(ecKey.X.Bytes()), "y": enc(ecKey.Y.Bytes())},
+		{"kty": "RSA", "kid": "rs", "n": enc(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": enc(rsaKey.N.Bytes()), "e": "AQAB"},
+	}})
+	keys, err := ParseJWKS(data)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	jwks := NewJWKS(func(context.Context) ([]byte, error) { return data, nil })
+	token := signToken(t, ES256, "es", ecKey, map[string]any{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()})
+	principal, err := NewJWTVerifier(jwks).Verify(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", principal.Subject)
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	secret := base64.RawURLEncoding.EncodeToString([]byte("secret"))
+	var loads atomic.Int32
+	release := make(chan struct{})
+	jwks := NewJWKS(func(context.Context) ([]byte, error) {
+		if loads.Add(1) > 1 {
+			<-release
+		}
+		return json.Marshal(map[string]any{"keys": []map[string]string{{"kty": "oct", "kid": "hs", "k": secret}}})
+	}, WithJWKSRefreshInterval(time.Millisecond))
+
+	ctx := context.Background()
+	keys, err := jwks.Keys(ctx, "hs")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	// the stale keys are served while the refresh is blocked
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		keys, err = jwks.Keys(ctx, "hs")
+		assert.NoError(t, err)
+		assert.Len(t, keys, 1)
+	}
+	assert.Eventually(t, func() bool { return loads.Load() == 2 }, time.Second, time.Millisecond)
+
+	// the waiters give up with their own context
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, jwks.Refresh(timeout), context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, jwks.Refresh(ctx))
+
+	// a hung load is given up by the load timeout
+	var hung atomic.Bool
+	hung.Store(true)
+	jwks = NewJWKS(func(ctx context.Context) ([]byte, error) {
+		if hung.Load() {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return json.Marshal(map[string]any{"keys": []map[string]string{{"kty": "oct", "kid": "hs", "k": secret}}})
+	}, WithJWKSLoadTimeout(10*time.Millisecond))
+	assert.ErrorIs(t, jwks.Refresh(ctx), context.DeadlineExceeded)
+	hung.Store(false)
+	assert.NoError(t, jwks.Refresh(ctx))
+}
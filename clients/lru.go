@@ -0,0 +1,66 @@
+package clients
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	lruEntry[K comparable, V any] struct {
+		key   K
+		value V
+	}
+
+	// lru is a concurrency safe least-recently-used memo.
+	lru[K comparable, V any] struct {
+		mu      sync.Mutex
+		list    list.List
+		entries map[K]*list.Element
+		maxLen  int
+	}
+)
+
+func newLRU[K comparable, V any](maxLen int) *lru[K, V] {
+	return &lru[K, V]{entries: map[K]*list.Element{}, maxLen: maxLen}
+}
+
+func (c *lru[K, V]) Get(key K) (v V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return v, false
+	}
+	c.list.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *lru[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxLen <= 0 {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		c.list.MoveToFront(e)
+		return
+	}
+	for c.list.Len() >= c.maxLen {
+		e := c.list.Back()
+		c.list.Remove(e)
+		delete(c.entries, e.Value.(*lruEntry[K, V]).key)
+	}
+	c.entries[key] = c.list.PushFront(&lruEntry[K, V]{key: key, value: value})
+}
+
+func (c *lru[K, V]) Resize(maxLen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxLen = maxLen
+	for c.list.Len() > 0 && c.list.Len() > maxLen {
+		e := c.list.Back()
+		c.list.Remove(e)
+		delete(c.entries, e.Value.(*lruEntry[K, V]).key)
+	}
+}
@@ -0,0 +1,77 @@
+package clients
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/peer"
+)
+
+// PeerIP returns the IP of the gRPC peer stored in ctx, or an empty string if the peer
+// is unknown or is not connected over IP (unix socket, in-memory listener...).
+func PeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	ip, _, err := net.SplitHostPort(strings.TrimSpace(p.Addr.String()))
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
+// ClientIPFromContext is the gRPC counterpart of ClientIP, it resolves the real client IP of
+// an incoming gRPC call with the same rules:
+//   - if TrustedPlatform is set and the metadata contains it, that value is returned;
+//   - the IP from peer.FromContext is used as the remote IP, if it isn't a trusted proxy it is returned;
+//   - otherwise the HeaderClientIP propagated by the client interceptors of an upstream service is used,
+//     then the metadata defined in RemoteIPHeaders when ForwardedByClientIP is enabled;
+//   - the remote IP is returned if none of the above is valid.
+//
+// The peers not connected over IP (unix socket, in-memory listener...) are local, the HeaderClientIP is
+// returned as is for them. Note the HeaderClientIP of an IP peer used to be trusted unconditionally,
+// now it's used only if the peer is a trusted proxy, DefaultIPResolver trusts no proxy, so call
+// SetTrustedProxies with the network of the upstream services to keep the propagated client IP.
+func (c *IPResolver) ClientIPFromContext(ctx context.Context) string {
+	return c.clientIPFromContext(ctx, HeaderClientIP)
+}
+
+func (c *IPResolver) clientIPFromContext(ctx context.Context, clientIPHeader string) string {
+	md := incomingMD(ctx)
+	if c.TrustedPlatform != "" {
+		if addr := defMD(md, c.TrustedPlatform, ""); addr != "" {
+			return addr
+		}
+	}
+
+	remoteIP := net.ParseIP(PeerIP(ctx))
+	if remoteIP == nil {
+		return defMD(md, clientIPHeader, "")
+	}
+	if !c.isTrustedProxy(remoteIP) {
+		return remoteIP.String()
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(defMD(md, clientIPHeader, ""))); ip != nil {
+		return ip.String()
+	}
+	if c.ForwardedByClientIP && c.RemoteIPHeaders != nil {
+		for _, headerName := range c.RemoteIPHeaders {
+			// a key may be appended multiple times by the proxies, joining them is equivalent to the http header
+			ip, valid := c.validateHeader(strings.Join(md.Get(headerName), ","))
+			if valid {
+				return ip
+			}
+		}
+	}
+	return remoteIP.String()
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	return DefaultIPResolver.ClientIPFromContext(ctx)
+}
@@ -0,0 +1,45 @@
+package clients
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func peerContext(addr string, kv ...string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+}
+
+func TestClientIPFromContext(t *testing.T) {
+	resolver := &IPResolver{
+		ForwardedByClientIP: true,
+		RemoteIPHeaders:     []string{"X-Forwarded-For"},
+	}
+	assert.NoError(t, resolver.SetTrustedProxies([]string{"10.0.0.0/8"}))
+
+	// untrusted peer, the metadata is ignored
+	ctx := peerContext("192.168.1.1:5000", HeaderClientIP, "1.1.1.1", "x-forwarded-for", "2.2.2.2")
+	assert.Equal(t, "192.168.1.1", resolver.ClientIPFromContext(ctx))
+
+	// trusted peer, the propagated client ip goes first
+	ctx = peerContext("10.0.0.1:5000", HeaderClientIP, "1.1.1.1", "x-forwarded-for", "2.2.2.2")
+	assert.Equal(t, "1.1.1.1", resolver.ClientIPFromContext(ctx))
+
+	// trusted peer, the forwarded chain is checked backwards
+	ctx = peerContext("10.0.0.1:5000", "x-forwarded-for", "3.3.3.3, 2.2.2.2", "x-forwarded-for", "10.0.0.2")
+	assert.Equal(t, "2.2.2.2", resolver.ClientIPFromContext(ctx))
+
+	// no peer
+	assert.Equal(t, "", resolver.ClientIPFromContext(context.Background()))
+
+	// the peer is not connected over IP, the propagated client ip is used
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: &net.UnixAddr{Name: "/tmp/grpc.sock", Net: "unix"}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(HeaderClientIP, "1.1.1.1"))
+	assert.Equal(t, "1.1.1.1", resolver.ClientIPFromContext(ctx))
+}
@@ -0,0 +1,126 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// PanicReporter is called with the recovered value and the stack after the panic is logged,
+// it can be used to report the panic to an error tracking service.
+type PanicReporter func(ctx context.Context, p any, stack []byte)
+
+// Recovery converts the panics of the handlers into 500 / codes.Internal, the request id of the ClientInfo
+// is logged with the stack and returned to the caller by HeaderRequestID.
+// It should be used after clients.Middleware or the clients server interceptors.
+type Recovery struct {
+	logger   *slog.Logger
+	reporter PanicReporter
+}
+
+type RecoveryOption func(*Recovery)
+
+// WithRecoveryLogger set the logger, slog.Default() is used by default.
+func WithRecoveryLogger(logger *slog.Logger) RecoveryOption {
+	return func(r *Recovery) {
+		r.logger = logger
+	}
+}
+
+func WithPanicReporter(reporter PanicReporter) RecoveryOption {
+	return func(r *Recovery) {
+		r.reporter = reporter
+	}
+}
+
+func NewRecovery(options ...RecoveryOption) *Recovery {
+	r := &Recovery{}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func (r *Recovery) handle(ctx context.Context, p any, method string) string {
+	stack := debug.Stack()
+	var requestID string
+	if info := FromContext(ctx); info != nil {
+		requestID = info.RequestID
+	}
+	logger := r.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.LogAttrs(ctx, slog.LevelError, "panic recovered",
+		slog.String("request_id", requestID),
+		slog.String("method", method),
+		slog.String("error", fmt.Sprint(p)),
+		slog.String("stack", string(stack)),
+	)
+	if r.reporter != nil {
+		r.reporter(ctx, p, stack)
+	}
+	return requestID
+}
+
+func (r *Recovery) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rw := NewResponseWriter(w)
+			if info := FromContext(req.Context()); info != nil && info.RequestID != "" {
+				rw.Header().Set(HeaderRequestID, info.RequestID)
+			}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					// the panic is used to abort the response on purpose
+					panic(p)
+				}
+				r.handle(req.Context(), p, req.Method+" "+req.URL.Path)
+				if !rw.Written() {
+					http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func (r *Recovery) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				if requestID := r.handle(ctx, p, info.FullMethod); requestID != "" {
+					_ = grpc.SetHeader(ctx, metadata.Pairs(HeaderRequestID, requestID))
+				}
+				resp, err = nil, status.Error(codes.Internal, "internal server error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func (r *Recovery) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				if requestID := r.handle(ss.Context(), p, info.FullMethod); requestID != "" {
+					_ = ss.SetHeader(metadata.Pairs(HeaderRequestID, requestID))
+				}
+				err = status.Error(codes.Internal, "internal server error")
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
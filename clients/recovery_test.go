@@ -0,0 +1,99 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// headerTransportStream captures the headers set by grpc.SetHeader.
+type headerTransportStream struct {
+	header metadata.MD
+}
+
+func (s *headerTransportStream) Method() string { return "/user.UserService/GetUser" }
+func (s *headerTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *headerTransportStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+func (s *headerTransportStream) SetTrailer(metadata.MD) error    { return nil }
+
+type headerServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *headerServerStream) Context() context.Context { return s.ctx }
+func (s *headerServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func newTestRecovery(reported *[]any) *Recovery {
+	return NewRecovery(
+		WithRecoveryLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithPanicReporter(func(ctx context.Context, p any, stack []byte) {
+			*reported = append(*reported, p)
+		}),
+	)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var reported []any
+	recovery := newTestRecovery(&reported)
+	handler := Middleware()(recovery.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderRequestID, "request-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "request-1", w.Header().Get(HeaderRequestID))
+	assert.Equal(t, []any{"boom"}, reported)
+
+	abort := recovery.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	assert.Len(t, reported, 1)
+}
+
+func TestRecoveryInterceptors(t *testing.T) {
+	var reported []any
+	recovery := newTestRecovery(&reported)
+	info := &ClientInfo{RequestID: "request-1"}
+
+	stream := &headerTransportStream{}
+	ctx := grpc.NewContextWithServerTransportStream(WithContext(context.Background(), info), stream)
+	resp, err := recovery.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: stream.Method()},
+		func(ctx context.Context, req any) (any, error) {
+			panic("boom")
+		})
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, []string{"request-1"}, stream.header.Get(HeaderRequestID))
+
+	ss := &headerServerStream{ctx: WithContext(context.Background(), info)}
+	err = recovery.StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{FullMethod: stream.Method()},
+		func(srv any, stream grpc.ServerStream) error {
+			panic("boom")
+		})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, []string{"request-1"}, ss.header.Get(HeaderRequestID))
+	assert.Len(t, reported, 2)
+}
@@ -0,0 +1,222 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cro4k/toolkit/cache"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ExtraTenant is the key of the tenant id in ClientInfo.Extra.
+const ExtraTenant = "tenant"
+
+var (
+	ErrTenantMissing = errors.New("tenant is missing")
+	ErrCrossTenant   = errors.New("cross-tenant propagation is not allowed")
+	ErrTenantSpoofed = errors.New("tenant header doesn't match the resolved tenant")
+)
+
+// Tenant returns the tenant id of the client.
+func (c *ClientInfo) Tenant() string {
+	return c.Extra[ExtraTenant]
+}
+
+// TenantFromContext returns the tenant id of the ClientInfo in the context.
+func TenantFromContext(ctx context.Context) string {
+	if info := FromContext(ctx); info != nil {
+		return info.Tenant()
+	}
+	return ""
+}
+
+// WithTenant returns a context with a copy of the ClientInfo whose tenant is replaced,
+// the ClientInfo is created if the context has none.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	info := &ClientInfo{}
+	if current := FromContext(ctx); current != nil {
+		*info = *current
+	}
+	extra := make(map[string]string, len(info.Extra)+1)
+	for key, val := range info.Extra {
+		extra[key] = val
+	}
+	extra[ExtraTenant] = tenant
+	info.Extra = extra
+	return WithContext(ctx, info)
+}
+
+// WithTenantFromHeader extracts the tenant id from the header, it is the same as WithExtraHeader(ExtraTenant, header).
+// Without this option the tenant id is propagated by x-client-extra-tenant, which is extracted with
+// WithExtraKeys(ExtraTenant). The header is trusted only if neither the claim nor the subdomain is configured,
+// otherwise the requests whose header differs from the resolved tenant are rejected with ErrTenantSpoofed.
+func WithTenantFromHeader(header string) ExtractorOption {
+	return WithExtraHeader(ExtraTenant, header)
+}
+
+// WithTenantFromSubdomain extracts the tenant id from the subdomain of the base domain, it takes precedence over
+// the header, and the claim takes precedence over it, for example the tenant of acme.example.com is acme with the base domain example.com.
+// The :authority of gRPC is used as the host.
+func WithTenantFromSubdomain(baseDomain string) ExtractorOption {
+	return func(e *Extractor) {
+		e.tenantBaseDomain = strings.ToLower(strings.Trim(baseDomain, "."))
+	}
+}
+
+// WithTenantFromClaim extracts the tenant id from the claim of the Principal, it takes precedence over the subdomain
+// and the header since it is authenticated. The JWTVerifier middleware or interceptors must run in front.
+func WithTenantFromClaim(claim string) ExtractorOption {
+	return func(e *Extractor) {
+		e.tenantClaim = claim
+	}
+}
+
+// WithTenantEnforcement make the client interceptors and Transport reject the outgoing calls whose tenant header,
+// set explicitly by the caller, is different from the tenant of the context.
+func WithTenantEnforcement() ExtractorOption {
+	return func(e *Extractor) {
+		e.enforceTenant = true
+	}
+}
+
+func (e *Extractor) tenantHeader() string {
+	if header, ok := e.extraHeaders[ExtraTenant]; ok {
+		return header
+	}
+	return HeaderExtraPrefix + ExtraTenant
+}
+
+func subdomain(host, baseDomain string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	sub, ok := strings.CutSuffix(host, "."+baseDomain)
+	if !ok || sub == "" {
+		return ""
+	}
+	// the nearest label to the base domain, e.g. acme of api.acme.example.com
+	return sub[strings.LastIndex(sub, ".")+1:]
+}
+
+// resolveTenant sets the tenant of the ClientInfo by the claim, then the subdomain, the tenant extracted from the header
+// is kept only if neither is configured, ErrTenantSpoofed is returned if it differs from the resolved tenant.
+func (e *Extractor) resolveTenant(ctx context.Context, info *ClientInfo, host string) error {
+	if e.tenantClaim == "" && e.tenantBaseDomain == "" {
+		return nil
+	}
+	var tenant string
+	if e.tenantClaim != "" {
+		if principal := PrincipalFromContext(ctx); principal != nil {
+			tenant, _ = principal.Claims[e.tenantClaim].(string)
+		}
+	}
+	if tenant == "" && e.tenantBaseDomain != "" {
+		tenant = subdomain(host, e.tenantBaseDomain)
+	}
+	header := info.Tenant()
+	delete(info.Extra, ExtraTenant)
+	if header != "" && header != tenant {
+		return ErrTenantSpoofed
+	}
+	if tenant == "" {
+		return nil
+	}
+	if info.Extra == nil {
+		info.Extra = map[string]string{}
+	}
+	info.Extra[ExtraTenant] = tenant
+	return nil
+}
+
+// checkTenant returns ErrCrossTenant if the tenant header in md is different from the tenant of the context.
+func (e *Extractor) checkTenant(ctx context.Context, values []string) error {
+	if !e.enforceTenant || len(values) == 0 {
+		return nil
+	}
+	tenant := TenantFromContext(ctx)
+	for _, val := range values {
+		if val != tenant {
+			return ErrCrossTenant
+		}
+	}
+	return nil
+}
+
+func (e *Extractor) checkOutgoingTenant(ctx context.Context) error {
+	out, _ := metadata.FromOutgoingContext(ctx)
+	if err := e.checkTenant(ctx, out.Get(e.tenantHeader())); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// TenantCache returns the cache whose keys are prefixed by the tenant id, the id is escaped so that
+// a tenant containing the separator can't reach the keys of another tenant.
+func TenantCache(c cache.Cache, tenant string) cache.Cache {
+	return cache.With(c, cache.WithPrefix("TENANT:"+url.QueryEscape(tenant)+":"))
+}
+
+type tenantCache struct {
+	cache cache.Cache
+}
+
+// nxTenantCache is returned by NewTenantCache if the cache implements cache.NXCache, so the atomicity is kept.
+type nxTenantCache struct {
+	*tenantCache
+}
+
+// NewTenantCache returns a cache which scopes the keys by the tenant of the context on each call,
+// ErrTenantMissing is returned if the context has no tenant. It implements cache.NXCache only if c does.
+func NewTenantCache(c cache.Cache) cache.Cache {
+	if _, ok := c.(cache.NXCache); ok {
+		return &nxTenantCache{tenantCache: &tenantCache{cache: c}}
+	}
+	return &tenantCache{cache: c}
+}
+
+func (c *tenantCache) scoped(ctx context.Context) (cache.Cache, error) {
+	tenant := TenantFromContext(ctx)
+	if tenant == "" {
+		return nil, ErrTenantMissing
+	}
+	return TenantCache(c.cache, tenant), nil
+}
+
+func (c *tenantCache) Get(ctx context.Context, key string) ([]byte, error) {
+	scoped, err := c.scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return scoped.Get(ctx, key)
+}
+
+func (c *tenantCache) Set(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	scoped, err := c.scoped(ctx)
+	if err != nil {
+		return err
+	}
+	return scoped.Set(ctx, key, value, exp)
+}
+
+func (c *nxTenantCache) SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error) {
+	scoped, err := c.scoped(ctx)
+	if err != nil {
+		return false, err
+	}
+	return cache.SetNX(ctx, scoped, key, value, exp)
+}
+
+func (c *tenantCache) Del(ctx context.Context, key string) error {
+	scoped, err := c.scoped(ctx)
+	if err != nil {
+		return err
+	}
+	return scoped.Del(ctx, key)
+}
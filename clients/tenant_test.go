@@ -0,0 +1,137 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cro4k/toolkit/cache"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTenantExtraction(t *testing.T) {
+	extract := func(extractor *Extractor, r *http.Request) (string, int) {
+		var tenant string
+		w := httptest.NewRecorder()
+		extractor.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant = TenantFromContext(r.Context())
+		})).ServeHTTP(w, r)
+		return tenant, w.Code
+	}
+	assertTenant := func(want string, extractor *Extractor, r *http.Request) {
+		t.Helper()
+		tenant, code := extract(extractor, r)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, want, tenant)
+	}
+	assertRejected := func(extractor *Extractor, r *http.Request) {
+		t.Helper()
+		_, code := extract(extractor, r)
+		assert.Equal(t, http.StatusForbidden, code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://acme.example.com/", nil)
+	assertTenant("", NewExtractor(), r)
+	assertTenant("acme", NewExtractor(WithTenantFromSubdomain("example.com")), r)
+
+	// the extra header is not extracted without configuration
+	r.Header.Set(HeaderExtraPrefix+ExtraTenant, "globex")
+	assertTenant("", NewExtractor(), r)
+	assertTenant("globex", NewExtractor(WithExtraKeys(ExtraTenant)), r)
+
+	// the spoofed header loses to the subdomain
+	assertRejected(NewExtractor(WithExtraKeys(ExtraTenant), WithTenantFromSubdomain("example.com")), r)
+	r.Header.Set(HeaderExtraPrefix+ExtraTenant, "acme")
+	assertTenant("acme", NewExtractor(WithExtraKeys(ExtraTenant), WithTenantFromSubdomain("example.com")), r)
+
+	r = httptest.NewRequest(http.MethodGet, "http://api.acme.example.com/", nil)
+	r.Header.Set("X-Tenant-ID", "globex")
+	assertTenant("globex", NewExtractor(WithTenantFromHeader("X-Tenant-ID")), r)
+	assertRejected(NewExtractor(WithTenantFromHeader("X-Tenant-ID"), WithTenantFromSubdomain("example.com")), r)
+	assertTenant("acme", NewExtractor(WithTenantFromSubdomain("example.com")), r)
+
+	// the claim is authoritative, the header is not a fallback of the missing claim
+	r = httptest.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+	r.Header.Set("X-Tenant-ID", "globex")
+	unclaimed := r.WithContext(WithPrincipal(r.Context(), &Principal{Subject: "user-1"}))
+	assertRejected(NewExtractor(WithTenantFromHeader("X-Tenant-ID"), WithTenantFromClaim("tid")), unclaimed)
+	claimed := r.WithContext(WithPrincipal(r.Context(), &Principal{Subject: "user-1", Claims: map[string]any{"tid": "initech"}}))
+	assertRejected(NewExtractor(WithTenantFromHeader("X-Tenant-ID"), WithTenantFromClaim("tid")), claimed)
+	claimed.Header.Del("X-Tenant-ID")
+	assertTenant("initech", NewExtractor(WithTenantFromHeader("X-Tenant-ID"), WithTenantFromClaim("tid")), claimed)
+
+	// the grpc calls are rejected with PermissionDenied
+	md := metadata.Pairs(":authority", "acme.example.com", HeaderExtraPrefix+ExtraTenant, "globex")
+	interceptor := NewExtractor(WithExtraKeys(ExtraTenant), WithTenantFromSubdomain("example.com")).UnaryServerInterceptor()
+	_, err := interceptor(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req any) (any, error) { return nil, nil })
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestTenantEnforcement(t *testing.T) {
+	extractor := NewExtractor(WithTenantEnforcement())
+	ctx := WithTenant(context.Background(), "acme")
+	header := HeaderExtraPrefix + ExtraTenant
+
+	assert.NoError(t, extractor.checkTenant(ctx, nil))
+	assert.NoError(t, extractor.checkTenant(ctx, []string{"acme"}))
+	assert.ErrorIs(t, extractor.checkTenant(ctx, []string{"acme", "globex"}), ErrCrossTenant)
+	assert.ErrorIs(t, extractor.checkTenant(context.Background(), []string{"acme"}), ErrCrossTenant)
+	assert.NoError(t, NewExtractor().checkTenant(ctx, []string{"globex"}))
+
+	var invoked bool
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked = true
+		return nil
+	}
+	interceptor := extractor.UnaryClientInterceptor()
+	err := interceptor(metadata.AppendToOutgoingContext(ctx, header, "globex"), "/user.UserService/GetUser", nil, nil, nil, invoker)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.False(t, invoked)
+	assert.NoError(t, interceptor(metadata.AppendToOutgoingContext(ctx, header, "acme"), "/user.UserService/GetUser", nil, nil, nil, invoker))
+	assert.True(t, invoked)
+}
+
+func TestTenantCache(t *testing.T) {
+	ctx := context.Background()
+	local := cache.NewLocalCache(100)
+	c := NewTenantCache(local)
+
+	_, err := c.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrTenantMissing)
+
+	acme, globex := WithTenant(ctx, "acme"), WithTenant(ctx, "globex")
+	assert.NoError(t, c.Set(acme, "key", []byte("acme"), time.Minute))
+	assert.NoError(t, c.Set(globex, "key", []byte("globex"), time.Minute))
+	value, err := c.Get(acme, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", string(value))
+	value, err = c.Get(globex, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "globex", string(value))
+
+	// the tenant "a:b" with the key "c" is not the tenant "a" with the key "b:c"
+	assert.NoError(t, c.Set(WithTenant(ctx, "a:b"), "c", []byte("a:b"), time.Minute))
+	_, err = c.Get(WithTenant(ctx, "a"), "b:c")
+	assert.ErrorIs(t, err, cache.ErrCacheNotFound)
+	value, err = local.Get(ctx, "TENANT:a%3Ab:c")
+	assert.NoError(t, err)
+	assert.Equal(t, "a:b", string(value))
+
+	assert.NoError(t, c.Del(acme, "key"))
+	_, err = c.Get(acme, "key")
+	assert.ErrorIs(t, err, cache.ErrCacheNotFound)
+	_, err = c.Get(globex, "key")
+	assert.NoError(t, err)
+
+	// SetNX is atomic only over the caches implementing it
+	assert.Implements(t, (*cache.NXCache)(nil), c)
+	_, ok := NewTenantCache(struct{ cache.Cache }{local}).(cache.NXCache)
+	assert.False(t, ok)
+}
@@ -0,0 +1,126 @@
+package clients
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderRequestTimeout carries the remaining time budget of the request in milliseconds,
+// a Go duration string (e.g. 1.5s) is also accepted when parsing.
+const HeaderRequestTimeout = "x-request-timeout"
+
+// maxRequestTimeoutMillis keeps the milliseconds from overflowing time.Duration.
+const maxRequestTimeoutMillis = int64(math.MaxInt64 / time.Millisecond)
+
+// ParseRequestTimeout parses HeaderRequestTimeout, the milliseconds are clamped to the range of time.Duration.
+func ParseRequestTimeout(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(max(min(ms, maxRequestTimeoutMillis), -maxRequestTimeoutMillis)) * time.Millisecond, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func FormatRequestTimeout(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms <= 0 && d > 0 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// RemainingTimeout returns the remaining budget of the context deadline.
+func RemainingTimeout(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// TimeoutMiddleware sets the deadline of the request context by HeaderRequestTimeout, the timeout is capped by limit,
+// and limit is used when the header is missing or invalid, zero limit means no limit.
+// The requests whose budget is already exhausted are rejected with 504 without calling the handler.
+func TimeoutMiddleware(limit time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := limit
+			if value := r.Header.Get(HeaderRequestTimeout); value != "" {
+				if d, err := ParseRequestTimeout(value); err == nil {
+					if d <= 0 {
+						http.Error(w, "request timeout budget exhausted", http.StatusGatewayTimeout)
+						return
+					}
+					if limit <= 0 || d < limit {
+						timeout = d
+					}
+				}
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Transport is the http counterpart of the client interceptors, it propagates the ClientInfo, the Principal token
+// and the remaining budget of the context deadline of the outgoing requests.
+type Transport struct {
+	Base http.RoundTripper
+	// Extractor decides the header names, the default one is used if it is nil.
+	Extractor *Extractor
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base}
+}
+
+// closeBody closes the request body on the early returns, as the RoundTripper contract requires.
+func closeBody(r *http.Request) {
+	if r.Body != nil {
+		_ = r.Body.Close()
+	}
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	extractor := t.Extractor
+	if extractor == nil {
+		extractor = defaultExtractor
+	}
+	if err := extractor.checkTenant(ctx, r.Header.Values(extractor.tenantHeader())); err != nil {
+		closeBody(r)
+		return nil, err
+	}
+	r = r.Clone(ctx)
+	if client := FromContext(ctx); client != nil {
+		for key, val := range extractor.MD(client) {
+			if len(val) > 0 && val[0] != "" && r.Header.Get(key) == "" {
+				r.Header.Set(key, val[0])
+			}
+		}
+	}
+	if principal := PrincipalFromContext(ctx); principal != nil && principal.Token != "" && r.Header.Get(HeaderAuthorization) == "" {
+		r.Header.Set(HeaderAuthorization, "Bearer "+principal.Token)
+	}
+	if remaining, ok := RemainingTimeout(ctx); ok {
+		if remaining <= 0 {
+			closeBody(r)
+			return nil, context.DeadlineExceeded
+		}
+		r.Header.Set(HeaderRequestTimeout, FormatRequestTimeout(remaining))
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,131 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"1500":  1500 * time.Millisecond,
+		" 20 ":  20 * time.Millisecond,
+		"1.5s":  1500 * time.Millisecond,
+		"-1":    -time.Millisecond,
+		"250ms": 250 * time.Millisecond,
+		// clamped instead of overflowing
+		"9223372036854775807":  time.Duration(maxRequestTimeoutMillis) * time.Millisecond,
+		"-9223372036854775808": -time.Duration(maxRequestTimeoutMillis) * time.Millisecond,
+	} {
+		d, err := ParseRequestTimeout(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, d, s)
+	}
+	_, err := ParseRequestTimeout("soon")
+	assert.Error(t, err)
+
+	assert.Equal(t, "1500", FormatRequestTimeout(1500*time.Millisecond))
+	assert.Equal(t, "1", FormatRequestTimeout(time.Microsecond))
+	assert.Equal(t, "0", FormatRequestTimeout(0))
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	serve := func(limit time.Duration, header string) (*httptest.ResponseRecorder, time.Duration, bool) {
+		var (
+			remaining time.Duration
+			ok        bool
+		)
+		handler := TimeoutMiddleware(limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remaining, ok = RemainingTimeout(r.Context())
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set(HeaderRequestTimeout, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w, remaining, ok
+	}
+
+	_, remaining, ok := serve(time.Second, "100")
+	assert.True(t, ok)
+	assert.True(t, remaining > 0 && remaining <= 100*time.Millisecond, remaining)
+
+	// capped by the limit
+	_, remaining, _ = serve(time.Second, "60000")
+	assert.True(t, remaining > 500*time.Millisecond && remaining <= time.Second, remaining)
+
+	// the limit is used for the invalid header
+	_, remaining, _ = serve(time.Second, "soon")
+	assert.True(t, remaining > 500*time.Millisecond && remaining <= time.Second, remaining)
+
+	_, _, ok = serve(0, "")
+	assert.False(t, ok)
+
+	w, _, ok := serve(time.Second, "0")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTransport(t *testing.T) {
+	var received *http.Request
+	transport := NewTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		received = r
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx = WithContext(ctx, &ClientInfo{ID: "device-1", RequestID: "request-1"})
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://user/users/1", nil)
+	_, err := transport.RoundTrip(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "request-1", received.Header.Get(HeaderRequestID))
+	budget, err := ParseRequestTimeout(received.Header.Get(HeaderRequestTimeout))
+	assert.NoError(t, err)
+	assert.True(t, budget > 0 && budget <= time.Second, budget)
+	assert.Empty(t, r.Header.Get(HeaderRequestTimeout), "the original request is not modified")
+
+	// the exhausted budget is not sent, and the body is closed
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	body := &closeRecorder{Reader: strings.NewReader("payload")}
+	r, _ = http.NewRequestWithContext(expired, http.MethodPost, "http://user/users", body)
+	received = nil
+	_, err = transport.RoundTrip(r)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, received)
+	assert.True(t, body.closed)
+
+	// the cross-tenant request is rejected, and the body is closed
+	transport.Extractor = NewExtractor(WithTenantEnforcement())
+	body = &closeRecorder{Reader: strings.NewReader("payload")}
+	r, _ = http.NewRequestWithContext(WithTenant(context.Background(), "acme"), http.MethodPost, "http://user/users", body)
+	r.Header.Set(HeaderExtraPrefix+ExtraTenant, "other")
+	_, err = transport.RoundTrip(r)
+	assert.ErrorIs(t, err, ErrCrossTenant)
+	assert.Nil(t, received)
+	assert.True(t, body.closed)
+}
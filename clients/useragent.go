@@ -0,0 +1,181 @@
+package clients
+
+import (
+	"regexp"
+	"strings"
+)
+
+type DeviceType string
+
+const (
+	DeviceUnknown DeviceType = "unknown"
+	DeviceDesktop DeviceType = "desktop"
+	DeviceMobile  DeviceType = "mobile"
+	DeviceTablet  DeviceType = "tablet"
+	DeviceBot     DeviceType = "bot"
+)
+
+// Device is the structured information parsed from the user agent.
+type Device struct {
+	Browser        string     `json:"browser,omitempty"`
+	BrowserVersion string     `json:"browser_version,omitempty"`
+	OS             string     `json:"os,omitempty"`
+	OSVersion      string     `json:"os_version,omitempty"`
+	Type           DeviceType `json:"type"`
+	Bot            bool       `json:"bot,omitempty"`
+}
+
+type uaRule struct {
+	name    string
+	pattern *regexp.Regexp
+	// version maps the first sub-match to the version, the sub-match is used as is if it's nil.
+	version func(string) string
+}
+
+func (r *uaRule) match(ua string) (string, bool) {
+	m := r.pattern.FindStringSubmatch(ua)
+	if m == nil {
+		return "", false
+	}
+	var version string
+	if len(m) > 1 {
+		version = m[1]
+	}
+	if r.version != nil {
+		version = r.version(version)
+	}
+	return version, true
+}
+
+func underscoreVersion(v string) string { return strings.ReplaceAll(v, "_", ".") }
+
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.2":  "XP",
+	"5.1":  "XP",
+}
+
+func windowsVersion(v string) string {
+	if name, ok := windowsVersions[v]; ok {
+		return name
+	}
+	return v
+}
+
+// the rules are checked in order, the more specific one must be put in front.
+var (
+	uaBotRules = []*uaRule{
+		{name: "Googlebot", pattern: regexp.MustCompile(`Googlebot(?:-\w+)?/([\d.]+)`)},
+		{name: "Bingbot", pattern: regexp.MustCompile(`bingbot/([\d.]+)`)},
+		{name: "Baiduspider", pattern: regexp.MustCompile(`Baiduspider(?:-\w+)?/([\d.]+)`)},
+		{name: "YandexBot", pattern: regexp.MustCompile(`YandexBot/([\d.]+)`)},
+		{name: "DuckDuckBot", pattern: regexp.MustCompile(`DuckDuckBot(?:-\w+)?/([\d.]+)`)},
+		{name: "Applebot", pattern: regexp.MustCompile(`Applebot/([\d.]+)`)},
+		{name: "facebookexternalhit", pattern: regexp.MustCompile(`facebookexternalhit/([\d.]+)`)},
+		{name: "Twitterbot", pattern: regexp.MustCompile(`Twitterbot/([\d.]+)`)},
+		{name: "curl", pattern: regexp.MustCompile(`^curl/([\d.]+)`)},
+		{name: "Wget", pattern: regexp.MustCompile(`^Wget/([\d.]+)`)},
+		{name: "python-requests", pattern: regexp.MustCompile(`^python-requests/([\d.]+)`)},
+		{name: "Go-http-client", pattern: regexp.MustCompile(`^Go-http-client/([\d.]+)`)},
+		{name: "HeadlessChrome", pattern: regexp.MustCompile(`HeadlessChrome/([\d.]+)`)},
+		{name: "Bot", pattern: regexp.MustCompile(`(?i)(?:bot|crawler|spider|crawling|slurp|scraper)`)},
+	}
+
+	uaBrowserRules = []*uaRule{
+		{name: "WeChat", pattern: regexp.MustCompile(`MicroMessenger/([\d.]+)`)},
+		{name: "Edge", pattern: regexp.MustCompile(`(?:Edge?|EdgA|EdgiOS)/([\d.]+)`)},
+		{name: "Opera", pattern: regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+		{name: "Samsung Internet", pattern: regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+		{name: "UC Browser", pattern: regexp.MustCompile(`UCBrowser/([\d.]+)`)},
+		{name: "Firefox", pattern: regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+		{name: "Chrome", pattern: regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+		{name: "Safari", pattern: regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+		{name: "Internet Explorer", pattern: regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+	}
+
+	uaOSRules = []*uaRule{
+		{name: "HarmonyOS", pattern: regexp.MustCompile(`HarmonyOS[ /]?([\d.]*)`)},
+		{name: "Windows Phone", pattern: regexp.MustCompile(`Windows Phone(?: OS)? ([\d.]+)`)},
+		{name: "Windows", pattern: regexp.MustCompile(`Windows NT ([\d.]+)`), version: windowsVersion},
+		{name: "iOS", pattern: regexp.MustCompile(`(?:iPhone|iPad|iPod).*? OS ([\d_]+)`), version: underscoreVersion},
+		{name: "Android", pattern: regexp.MustCompile(`Android[ /]?([\d.]*)`)},
+		{name: "macOS", pattern: regexp.MustCompile(`Mac OS X ?([\d_.]*)`), version: underscoreVersion},
+		{name: "Chrome OS", pattern: regexp.MustCompile(`CrOS \S+ ([\d.]+)`)},
+		{name: "Linux", pattern: regexp.MustCompile(`Linux`)},
+	}
+
+	uaTabletPattern = regexp.MustCompile(`(?i)iPad|Tablet|PlayBook|Kindle|Silk/`)
+	uaMobilePattern = regexp.MustCompile(`(?i)Mobile|iPhone|iPod|Android|Windows Phone|BlackBerry|Opera Mini`)
+)
+
+const (
+	defaultUserAgentMemoSize = 4096
+	// maxMemoUserAgentLen keeps the memo small, the longer user agents are parsed without being memorized.
+	maxMemoUserAgentLen = 512
+)
+
+var userAgentMemo = newLRU[string, *Device](defaultUserAgentMemoSize)
+
+// SetUserAgentMemoSize set the number of the recent user agents whose parsed result is memorized,
+// zero disables the memo.
+func SetUserAgentMemoSize(n int) {
+	userAgentMemo.Resize(n)
+}
+
+// ParseUserAgent parses the user agent with the embedded rules, the result of the user agent no longer than
+// 512 bytes is memorized, it's shared by all the callers with the same user agent and must not be modified.
+func ParseUserAgent(ua string) *Device {
+	if len(ua) > maxMemoUserAgentLen {
+		return parseUserAgent(ua)
+	}
+	if d, ok := userAgentMemo.Get(ua); ok {
+		return d
+	}
+	d := parseUserAgent(ua)
+	userAgentMemo.Add(ua, d)
+	return d
+}
+
+func parseUserAgent(ua string) *Device {
+	d := &Device{Type: DeviceUnknown}
+	if ua == "" {
+		return d
+	}
+	for _, rule := range uaBotRules {
+		if version, ok := rule.match(ua); ok {
+			d.Browser, d.BrowserVersion = rule.name, version
+			d.Bot, d.Type = true, DeviceBot
+			break
+		}
+	}
+	if !d.Bot {
+		for _, rule := range uaBrowserRules {
+			if version, ok := rule.match(ua); ok {
+				d.Browser, d.BrowserVersion = rule.name, version
+				break
+			}
+		}
+	}
+	for _, rule := range uaOSRules {
+		if version, ok := rule.match(ua); ok {
+			d.OS, d.OSVersion = rule.name, version
+			break
+		}
+	}
+	if d.Bot {
+		return d
+	}
+	switch {
+	case uaTabletPattern.MatchString(ua), d.OS == "Android" && !strings.Contains(ua, "Mobile"):
+		d.Type = DeviceTablet
+	case uaMobilePattern.MatchString(ua):
+		d.Type = DeviceMobile
+	case d.OS == "Windows", d.OS == "macOS", d.OS == "Linux", d.OS == "Chrome OS":
+		d.Type = DeviceDesktop
+	}
+	return d
+}
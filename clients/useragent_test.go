@@ -0,0 +1,55 @@
+package clients
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := map[string]Device{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36": {
+			Browser: "Chrome", BrowserVersion: "120.0.0.0", OS: "Windows", OSVersion: "10", Type: DeviceDesktop,
+		},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91": {
+			Browser: "Edge", BrowserVersion: "120.0.2210.91", OS: "Windows", OSVersion: "10", Type: DeviceDesktop,
+		},
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1": {
+			Browser: "Safari", BrowserVersion: "17.1.2", OS: "iOS", OSVersion: "17.1.2", Type: DeviceMobile,
+		},
+		"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/119.0.6045.169 Mobile/15E148 Safari/604.1": {
+			Browser: "Chrome", BrowserVersion: "119.0.6045.169", OS: "iOS", OSVersion: "16.6", Type: DeviceTablet,
+		},
+		"Mozilla/5.0 (Linux; Android 13; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36": {
+			Browser: "Samsung Internet", BrowserVersion: "23.0", OS: "Android", OSVersion: "13", Type: DeviceMobile,
+		},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:121.0) Gecko/20100101 Firefox/121.0": {
+			Browser: "Firefox", BrowserVersion: "121.0", OS: "macOS", OSVersion: "10.15", Type: DeviceDesktop,
+		},
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": {
+			Browser: "Googlebot", BrowserVersion: "2.1", Type: DeviceBot, Bot: true,
+		},
+		"curl/8.4.0": {
+			Browser: "curl", BrowserVersion: "8.4.0", Type: DeviceBot, Bot: true,
+		},
+		"": {Type: DeviceUnknown},
+	}
+	for ua, expected := range cases {
+		assert.Equal(t, &expected, ParseUserAgent(ua), ua)
+	}
+}
+
+func TestUserAgentMemo(t *testing.T) {
+	ua := "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	assert.Same(t, ParseUserAgent(ua), ParseUserAgent(ua))
+
+	// the long user agents are not memorized
+	long := ua + " " + strings.Repeat("x", maxMemoUserAgentLen)
+	assert.NotSame(t, ParseUserAgent(long), ParseUserAgent(long))
+	assert.Equal(t, "Firefox", ParseUserAgent(long).Browser)
+
+	SetUserAgentMemoSize(0)
+	defer SetUserAgentMemoSize(defaultUserAgentMemoSize)
+	assert.NotSame(t, ParseUserAgent(ua), ParseUserAgent(ua))
+}
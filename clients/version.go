@@ -0,0 +1,143 @@
+package clients
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the parsed form of HeaderVersion, the format is [platform/]major[.minor[.patch]][-pre][+build].
+// example:
+//
+//	3.2.1
+//	v3.2
+//	ios/3.2.1
+//	android/1.0.0-beta.2
+type Version struct {
+	Platform   string
+	Major      int
+	Minor      int
+	Patch      int
+	PreRelease string
+}
+
+func ParseVersion(s string) (*Version, error) {
+	text := strings.TrimSpace(s)
+	v := &Version{}
+	if platform, rest, ok := strings.Cut(text, "/"); ok {
+		v.Platform = strings.ToLower(strings.TrimSpace(platform))
+		text = strings.TrimSpace(rest)
+	}
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "v"), "V")
+	text, _, _ = strings.Cut(text, "+")
+	text, v.PreRelease, _ = strings.Cut(text, "-")
+
+	parts := strings.Split(text, ".")
+	if text == "" || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version: %q", s)
+	}
+	numbers := [3]int{}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version: %q", s)
+		}
+		numbers[i] = n
+	}
+	v.Major, v.Minor, v.Patch = numbers[0], numbers[1], numbers[2]
+	return v, nil
+}
+
+func MustParseVersion(s string) *Version {
+	v, err := ParseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// String returns the version without the platform.
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.PreRelease != "" {
+		s += "-" + v.PreRelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 when v is less than, equal to or greater than o.
+// The platform is ignored, the pre-release is compared as defined by semver, which means
+// 1.0.0-alpha < 1.0.0-alpha.1 < 1.0.0-beta < 1.0.0.
+func (v *Version) Compare(o *Version) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePreRelease(v.PreRelease, o.PreRelease)
+}
+
+func (v *Version) LessThan(o *Version) bool    { return v.Compare(o) < 0 }
+func (v *Version) GreaterThan(o *Version) bool { return v.Compare(o) > 0 }
+func (v *Version) Equal(o *Version) bool       { return v.Compare(o) == 0 }
+
+// AtLeast reports whether v is greater than or equal to the version string min.
+// It returns false if min is invalid.
+func (v *Version) AtLeast(min string) bool {
+	o, err := ParseVersion(min)
+	if err != nil {
+		return false
+	}
+	return v.Compare(o) >= 0
+}
+
+// ParseVersion parses the ClientInfo.Version.
+func (c *ClientInfo) ParseVersion() (*Version, error) {
+	return ParseVersion(c.Version)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func comparePreRelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInt(an, bn)
+		case aErr == nil:
+			c = -1 // numeric identifiers have lower precedence
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
@@ -0,0 +1,222 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AnyPlatform is the platform key of the requirement applied to the clients which platform
+	// has no explicit requirement.
+	AnyPlatform = ""
+
+	ReasonUpgradeRequired = "UPGRADE_REQUIRED"
+)
+
+// UpgradeRequiredError is returned by VersionGate.Check when the client version is below the minimum,
+// it is encoded as the json body of the http response.
+type UpgradeRequiredError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Platform   string `json:"platform,omitempty"`
+	Current    string `json:"current,omitempty"`
+	Minimum    string `json:"minimum"`
+	UpgradeURL string `json:"upgrade_url,omitempty"`
+}
+
+func (e *UpgradeRequiredError) Error() string {
+	return e.Message
+}
+
+// GRPCStatus makes the error can be converted by status.FromError, the details are carried by errdetails.ErrorInfo.
+func (e *UpgradeRequiredError) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, e.Message)
+	md := map[string]string{"minimum": e.Minimum}
+	if e.Platform != "" {
+		md["platform"] = e.Platform
+	}
+	if e.Current != "" {
+		md["current"] = e.Current
+	}
+	if e.UpgradeURL != "" {
+		md["upgrade_url"] = e.UpgradeURL
+	}
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: e.Code, Domain: "clients", Metadata: md}); err == nil {
+		return detailed
+	}
+	return st
+}
+
+type versionRequirement struct {
+	minimum    *Version
+	upgradeURL string
+}
+
+// VersionGate rejects the clients which version is below the minimum version of its platform.
+type VersionGate struct {
+	requirements  map[string]*versionRequirement
+	rejectUnknown bool
+	redirect      bool
+	err           error
+}
+
+type VersionGateOption func(*VersionGate)
+
+// WithMinVersion set the minimum version of the platform, use AnyPlatform as the default requirement.
+func WithMinVersion(platform, minimum string) VersionGateOption {
+	return func(g *VersionGate) {
+		v, err := ParseVersion(minimum)
+		if err != nil {
+			g.err = err
+			return
+		}
+		g.requirement(platform).minimum = v
+	}
+}
+
+// WithUpgradeURL set the url where the clients of the platform can get the new version.
+func WithUpgradeURL(platform, url string) VersionGateOption {
+	return func(g *VersionGate) {
+		g.requirement(platform).upgradeURL = url
+	}
+}
+
+// WithUpgradeRedirect make the http middleware redirect the outdated clients to the upgrade url
+// instead of responding 426 Upgrade Required, it has no effect on the platforms without upgrade url.
+func WithUpgradeRedirect() VersionGateOption {
+	return func(g *VersionGate) {
+		g.redirect = true
+	}
+}
+
+// WithRejectUnknownVersion make the gate reject the clients without a valid version,
+// by default they are allowed.
+func WithRejectUnknownVersion() VersionGateOption {
+	return func(g *VersionGate) {
+		g.rejectUnknown = true
+	}
+}
+
+func NewVersionGate(options ...VersionGateOption) (*VersionGate, error) {
+	g := &VersionGate{requirements: map[string]*versionRequirement{}}
+	for _, option := range options {
+		option(g)
+	}
+	if g.err != nil {
+		return nil, g.err
+	}
+	return g, nil
+}
+
+// the platforms are case-insensitive, e.g. iOS matches ios.
+func (g *VersionGate) requirement(platform string) *versionRequirement {
+	platform = strings.ToLower(platform)
+	if r, ok := g.requirements[platform]; ok {
+		return r
+	}
+	r := &versionRequirement{}
+	g.requirements[platform] = r
+	return r
+}
+
+func (g *VersionGate) lookup(platform string) *versionRequirement {
+	platform = strings.ToLower(platform)
+	if r, ok := g.requirements[platform]; ok && r.minimum != nil {
+		return r
+	}
+	if r, ok := g.requirements[AnyPlatform]; ok && r.minimum != nil {
+		return r
+	}
+	return nil
+}
+
+// Check returns an *UpgradeRequiredError if the version is not allowed.
+func (g *VersionGate) Check(version string) error {
+	v, err := ParseVersion(version)
+	if err != nil {
+		if !g.rejectUnknown {
+			return nil
+		}
+		r := g.lookup(AnyPlatform)
+		e := &UpgradeRequiredError{
+			Code:    ReasonUpgradeRequired,
+			Message: fmt.Sprintf("unknown client version %q", version),
+			Current: version,
+		}
+		if r != nil {
+			e.Minimum = r.minimum.String()
+			e.UpgradeURL = r.upgradeURL
+		}
+		return e
+	}
+	r := g.lookup(v.Platform)
+	if r == nil || !v.LessThan(r.minimum) {
+		return nil
+	}
+	return &UpgradeRequiredError{
+		Code:       ReasonUpgradeRequired,
+		Message:    fmt.Sprintf("client version %s is below the minimum version %s", v, r.minimum),
+		Platform:   v.Platform,
+		Current:    v.String(),
+		Minimum:    r.minimum.String(),
+		UpgradeURL: r.upgradeURL,
+	}
+}
+
+func (g *VersionGate) checkContext(ctx context.Context) error {
+	if info := FromContext(ctx); info != nil {
+		return g.Check(info.Version)
+	}
+	return g.Check(defMD(incomingMD(ctx), HeaderVersion, ""))
+}
+
+// Middleware should be used after clients.Middleware, otherwise the version is read from the request header.
+func (g *VersionGate) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version := r.Header.Get(HeaderVersion)
+			if info := FromContext(r.Context()); info != nil {
+				version = info.Version
+			}
+			err := g.Check(version)
+			if err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			e, _ := err.(*UpgradeRequiredError)
+			if g.redirect && e.UpgradeURL != "" {
+				http.Redirect(w, r, e.UpgradeURL, http.StatusFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUpgradeRequired)
+			_ = json.NewEncoder(w).Encode(e)
+		})
+	}
+}
+
+func (g *VersionGate) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := g.checkContext(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (g *VersionGate) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := g.checkContext(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,59 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("iOS/v3.2.1-beta.1+build.7")
+	assert.NoError(t, err)
+	assert.Equal(t, &Version{Platform: "ios", Major: 3, Minor: 2, Patch: 1, PreRelease: "beta.1"}, v)
+
+	v, err = ParseVersion("3.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "3.2.0", v.String())
+
+	for _, s := range []string{"", "ios/", "a.b.c", "1.2.3.4", "1.-2"} {
+		_, err = ParseVersion(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.0.1", "1.1", "2"}
+	for i := 1; i < len(ordered); i++ {
+		assert.True(t, MustParseVersion(ordered[i-1]).LessThan(MustParseVersion(ordered[i])), ordered[i])
+	}
+	assert.True(t, MustParseVersion("ios/1.0").Equal(MustParseVersion("android/1.0.0")))
+}
+
+func TestVersionGate(t *testing.T) {
+	gate, err := NewVersionGate(
+		WithMinVersion(AnyPlatform, "1.0.0"),
+		WithMinVersion("ios", "3.2.0"),
+		WithUpgradeURL("ios", "https://apps.apple.com/app"),
+	)
+	assert.NoError(t, err)
+
+	assert.NoError(t, gate.Check("android/1.0.0"))
+	assert.NoError(t, gate.Check("ios/3.2.0"))
+	assert.NoError(t, gate.Check(""))
+
+	var e *UpgradeRequiredError
+	assert.True(t, errors.As(gate.Check("ios/3.1.9"), &e))
+	assert.Equal(t, "3.2.0", e.Minimum)
+	assert.Equal(t, "https://apps.apple.com/app", e.UpgradeURL)
+	assert.Error(t, gate.Check("web/0.9"))
+
+	mixed, err := NewVersionGate(WithMinVersion("iOS", "3.2.0"))
+	assert.NoError(t, err)
+	assert.Error(t, mixed.Check("ios/3.1.0"))
+	assert.Error(t, mixed.Check("IOS/3.1.0"))
+	assert.NoError(t, mixed.Check("android/1.0.0"))
+
+	_, err = NewVersionGate(WithMinVersion("ios", "latest"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,191 @@
+package cluster
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// WeightedRoundRobin is the name of the balancer which picks the addresses resolved by the cluster resolver
+// by smooth weighted round-robin, the weight is MetadataWeight of the endpoint, 1 by default.
+//
+// The picks can be restricted to a subset of the endpoints by WithSubset, for example route the canary
+// requests to the endpoints of a version:
+//
+//	ctx = cluster.WithSubset(ctx, cluster.MetadataVersion, "1.3.0-canary")
+//	resp, err := client.GetUser(ctx, req)
+//
+// All the endpoints are picked if no endpoint matches the subset.
+const WeightedRoundRobin = "cluster_weighted_round_robin"
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(WeightedRoundRobin, &weightedPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+// WithWeightedRoundRobin returns the DialOption to use WeightedRoundRobin as the default balancer.
+func WithWeightedRoundRobin() grpc.DialOption {
+	return grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"` + WeightedRoundRobin + `":{}}]}`)
+}
+
+type subsetContextKey struct{}
+
+// WithSubset restricts the picks of WeightedRoundRobin to the endpoints whose metadata of the key has one of the values,
+// the conditions of different keys are all required.
+func WithSubset(ctx context.Context, key string, values ...string) context.Context {
+	subset := Metadata{}
+	if current, ok := ctx.Value(subsetContextKey{}).(Metadata); ok {
+		for k, v := range current {
+			subset[k] = slices.Clone(v)
+		}
+	}
+	subset.Add(key, values...)
+	return context.WithValue(ctx, subsetContextKey{}, subset)
+}
+
+func subsetFromContext(ctx context.Context) Metadata {
+	subset, _ := ctx.Value(subsetContextKey{}).(Metadata)
+	return subset
+}
+
+// key returns the canonical string of the subset.
+func (m Metadata) key() string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		values := slices.Clone(m[key])
+		sort.Strings(values)
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(values, ","))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// match reports whether the metadata matches the subset.
+func (m Metadata) match(subset Metadata) bool {
+	for key, values := range subset {
+		matched := false
+		for _, val := range m.Gets(key) {
+			if slices.Contains(values, val) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+type weightedConn struct {
+	conn     balancer.SubConn
+	metadata Metadata
+	weight   int
+	current  int
+}
+
+// smoothWeighted is the smooth weighted round-robin of nginx.
+type smoothWeighted struct {
+	mu    sync.Mutex
+	conns []*weightedConn
+	total int
+}
+
+func newSmoothWeighted(conns []*weightedConn) *smoothWeighted {
+	w := &smoothWeighted{}
+	for _, conn := range conns {
+		c := *conn
+		w.conns = append(w.conns, &c)
+		w.total += c.weight
+	}
+	return w
+}
+
+func (w *smoothWeighted) next() balancer.SubConn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var best *weightedConn
+	for _, conn := range w.conns {
+		conn.current += conn.weight
+		if best == nil || conn.current > best.current {
+			best = conn
+		}
+	}
+	best.current -= w.total
+	return best.conn
+}
+
+func endpointWeight(metadata Metadata) int {
+	weight, err := strconv.Atoi(metadata.Get(MetadataWeight))
+	if err != nil || weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+type weightedPickerBuilder struct{}
+
+func (*weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	conns := make([]*weightedConn, 0, len(info.ReadySCs))
+	addrs := make(map[balancer.SubConn]string, len(info.ReadySCs))
+	for conn, sc := range info.ReadySCs {
+		metadata := MetadataFromAddress(sc.Address)
+		conns = append(conns, &weightedConn{conn: conn, metadata: metadata, weight: endpointWeight(metadata)})
+		addrs[conn] = sc.Address.Addr
+	}
+	sort.Slice(conns, func(i, j int) bool { return addrs[conns[i].conn] < addrs[conns[j].conn] })
+	return &weightedPicker{conns: conns, all: newSmoothWeighted(conns), subsets: map[string]*smoothWeighted{}}
+}
+
+type weightedPicker struct {
+	conns []*weightedConn
+	all   *smoothWeighted
+
+	mu      sync.Mutex
+	subsets map[string]*smoothWeighted
+}
+
+func (p *weightedPicker) subset(subset Metadata) *smoothWeighted {
+	key := subset.key()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if w, ok := p.subsets[key]; ok {
+		return w
+	}
+	var conns []*weightedConn
+	for _, conn := range p.conns {
+		if conn.metadata.match(subset) {
+			conns = append(conns, conn)
+		}
+	}
+	w := p.all
+	if len(conns) > 0 {
+		w = newSmoothWeighted(conns)
+	}
+	p.subsets[key] = w
+	return w
+}
+
+func (p *weightedPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	w := p.all
+	if subset := subsetFromContext(info.Ctx); len(subset) > 0 {
+		w = p.subset(subset)
+	}
+	return balancer.PickResult{SubConn: w.next()}, nil
+}
@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+func startHealthServer(t *testing.T) (string, uint16) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	addr := lis.Addr().(*net.TCPAddr)
+	return addr.IP.String(), uint16(addr.Port)
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := NewMemoryRegistry()
+	host1, port1 := startHealthServer(t)
+	host2, port2 := startHealthServer(t)
+	stable := NewEndpoint("user-service", host1, port1, WithMetadata(MetadataVersion, "1.0.0"))
+	canary := NewEndpoint("user-service", host2, port2, WithMetadata(MetadataVersion, "1.1.0"), WithMetadata(MetadataWeight, "3"))
+	_ = registry.Register(ctx, stable)
+	_ = registry.Register(ctx, canary)
+
+	conn, err := grpc.Dial("cluster:///user-service",
+		grpc.WithResolvers(NewResolverBuilder(registry)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		WithWeightedRoundRobin(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	pick := func(ctx context.Context) string {
+		var p peer.Peer
+		if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Peer(&p), grpc.WaitForReady(true)); err != nil {
+			t.Fatal(err)
+		}
+		return p.Addr.String()
+	}
+	// wait until both endpoints are ready
+	for seen := map[string]bool{}; len(seen) < 2; {
+		seen[pick(ctx)] = true
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[pick(ctx)]++
+	}
+	if counts[stable.Address()] != 2 || counts[canary.Address()] != 6 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+
+	subsetCtx := WithSubset(ctx, MetadataVersion, "1.0.0")
+	for i := 0; i < 4; i++ {
+		if addr := pick(subsetCtx); addr != stable.Address() {
+			t.Errorf("subset picked %s", addr)
+		}
+	}
+}
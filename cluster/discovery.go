@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventAdd EventType = iota + 1
+	EventUpdate
+	EventRemove
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdd:
+		return "add"
+	case EventUpdate:
+		return "update"
+	case EventRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change of the endpoints of a service, Endpoint is the last known state for EventRemove.
+type Event struct {
+	Type     EventType
+	Endpoint *Endpoint
+}
+
+type Discovery interface {
+	GetService(ctx context.Context, name string) ([]*Endpoint, error)
+	// Watch streams the changes of the service, the current endpoints are sent as EventAdd first,
+	// the channel is closed when ctx is done.
+	Watch(ctx context.Context, name string) (<-chan Event, error)
+}
+
+const defaultWatchDebounce = 100 * time.Millisecond
+
+// watcher reloads the endpoints when notified, and sends the difference from the last snapshot.
+// The notifications within debounce are merged into one reload, and the endpoints are reloaded
+// every resync to catch the changes without notification (e.g. expiration), zero resync disables it.
+type watcher struct {
+	load     func(ctx context.Context) ([]*Endpoint, error)
+	debounce time.Duration
+	resync   time.Duration
+	// store is called with the snapshot after each successful reload.
+	store func([]*Endpoint)
+	// onError is called when reload failed, the last snapshot is kept.
+	onError func(error)
+}
+
+type snapshotEntry struct {
+	endpoint *Endpoint
+	data     []byte
+}
+
+func (w *watcher) snapshot(endpoints []*Endpoint) map[string]snapshotEntry {
+	snapshot := make(map[string]snapshotEntry, len(endpoints))
+	for _, endpoint := range endpoints {
+		data, _ := json.Marshal(endpoint)
+		snapshot[endpoint.nodeID] = snapshotEntry{endpoint: endpoint, data: data}
+	}
+	return snapshot
+}
+
+func diffSnapshot(prev, next map[string]snapshotEntry) []Event {
+	var events []Event
+	for nodeID, entry := range next {
+		old, ok := prev[nodeID]
+		if !ok {
+			events = append(events, Event{Type: EventAdd, Endpoint: entry.endpoint.clone()})
+		} else if string(old.data) != string(entry.data) {
+			events = append(events, Event{Type: EventUpdate, Endpoint: entry.endpoint.clone()})
+		}
+	}
+	for nodeID, entry := range prev {
+		if _, ok := next[nodeID]; !ok {
+			events = append(events, Event{Type: EventRemove, Endpoint: entry.endpoint.clone()})
+		}
+	}
+	return events
+}
+
+// start loads the current endpoints and runs the watcher in background until ctx is done,
+// stop is called when the watcher exits or the first load failed.
+func (w *watcher) start(ctx context.Context, notify <-chan struct{}, stop func()) (<-chan Event, error) {
+	endpoints, err := w.load(ctx)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	if w.store != nil {
+		w.store(endpoints)
+	}
+	events := make(chan Event, len(endpoints))
+	for _, endpoint := range endpoints {
+		events <- Event{Type: EventAdd, Endpoint: endpoint.clone()}
+	}
+	go func() {
+		defer close(events)
+		defer stop()
+		w.run(ctx, notify, w.snapshot(endpoints), events)
+	}()
+	return events, nil
+}
+
+func (w *watcher) run(ctx context.Context, notify <-chan struct{}, current map[string]snapshotEntry, events chan<- Event) {
+	var resync <-chan time.Time
+	if w.resync > 0 {
+		ticker := time.NewTicker(w.resync)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+	debounce := time.NewTimer(w.debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	pending := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-notify:
+			if !pending {
+				pending = true
+				debounce.Reset(w.debounce)
+			}
+			continue
+		case <-debounce.C:
+			pending = false
+		case <-resync:
+		}
+
+		endpoints, err := w.load(ctx)
+		if err != nil {
+			if ctx.Err() == nil && w.onError != nil {
+				w.onError(err)
+			}
+			continue
+		}
+		if w.store != nil {
+			w.store(endpoints)
+		}
+		next := w.snapshot(endpoints)
+		for _, event := range diffSnapshot(current, next) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		current = next
+	}
+}
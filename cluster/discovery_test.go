@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestMemoryRegistryWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := NewMemoryRegistry(WithMemoryRegistryDebounce(10 * time.Millisecond))
+	node1 := NewEndpoint("user-service", "10.0.0.1", 8080, WithNodeID("node-001"))
+	if err := registry.Register(ctx, node1); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := registry.Watch(ctx, "user-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Type != EventAdd || event.Endpoint.NodeID() != "node-001" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+
+	node2 := NewEndpoint("user-service", "10.0.0.2", 8080, WithNodeID("node-002"))
+	_ = registry.Register(ctx, node2)
+	if event := receiveEvent(t, events); event.Type != EventAdd || event.Endpoint.NodeID() != "node-002" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+
+	node1.Metadata().Set("version", "1.1.0")
+	_ = registry.Register(ctx, node1)
+	if event := receiveEvent(t, events); event.Type != EventUpdate || event.Endpoint.Metadata().Get("version") != "1.1.0" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+
+	_ = registry.Deregister(ctx, node2)
+	if event := receiveEvent(t, events); event.Type != EventRemove || event.Endpoint.NodeID() != "node-002" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+
+	endpoints, _ := registry.GetService(ctx, "user-service")
+	if len(endpoints) != 1 || endpoints[0].NodeID() != "node-001" {
+		t.Errorf("unexpected endpoints: %v", endpoints)
+	}
+
+	cancel()
+	for range events {
+	}
+}
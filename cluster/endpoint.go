@@ -2,11 +2,14 @@ package cluster
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 type Endpoint struct {
@@ -26,6 +29,79 @@ func (e *Endpoint) Port() uint16       { return e.port }
 func (e *Endpoint) NodeID() string     { return e.nodeID }
 func (e *Endpoint) Metadata() Metadata { return e.metadata }
 
+func (e *Endpoint) HealthyConfig() *HealthyConfig { return e.healthy }
+
+// clone returns a deep copy of the endpoint, so that the copy can be handed out without sharing the metadata.
+func (e *Endpoint) clone() *Endpoint {
+	c := *e
+	c.metadata = e.metadata.clone()
+	if e.healthy != nil {
+		healthy := *e.healthy
+		healthy.Options = e.healthy.Options.clone()
+		c.healthy = &healthy
+	}
+	return &c
+}
+
+// Address returns host:port of the endpoint.
+func (e *Endpoint) Address() string {
+	return net.JoinHostPort(e.host, strconv.Itoa(int(e.port)))
+}
+
+// endpointJSON is the JSON format of Endpoint, which is shared by the registries:
+//
+//	{
+//	  "service": "user-service",
+//	  "host": "10.0.0.1",
+//	  "port": 8080,
+//	  "node_id": "5f0c6a0e-...",
+//	  "metadata": {"zone": ["cn-east-1a"], "version": ["1.2.0"]},
+//	  "healthy": {"protocol": "grpc", "target": "10.0.0.1:8080", "options": {}}
+//	}
+//
+// The metadata keys are lower case, "healthy" is omitted if the endpoint has no HealthyConfig.
+type endpointJSON struct {
+	Service  string         `json:"service"`
+	Host     string         `json:"host"`
+	Port     uint16         `json:"port"`
+	NodeID   string         `json:"node_id"`
+	Metadata Metadata       `json:"metadata,omitempty"`
+	Healthy  *HealthyConfig `json:"healthy,omitempty"`
+}
+
+func (e *Endpoint) MarshalJSON() ([]byte, error) {
+	healthy := e.healthy
+	if healthy != nil && healthy.Protocol != nil && localOnly(healthy.Protocol.protocol()) {
+		// the local-only checks can't be decoded by the consumers
+		healthy = nil
+	}
+	return json.Marshal(&endpointJSON{
+		Service:  e.service,
+		Host:     e.host,
+		Port:     e.port,
+		NodeID:   e.nodeID,
+		Metadata: e.metadata,
+		Healthy:  healthy,
+	})
+}
+
+func (e *Endpoint) UnmarshalJSON(data []byte) error {
+	var v endpointJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Service == "" || v.NodeID == "" {
+		return errors.New("invalid endpoint: service and node_id are required")
+	}
+	metadata := Metadata{}
+	for key, val := range v.Metadata {
+		metadata.Add(key, val...)
+	}
+	e.service, e.host, e.port, e.nodeID = v.Service, v.Host, v.Port, v.NodeID
+	e.metadata, e.healthy = metadata, v.Healthy
+	return nil
+}
+
 type EndpointOption func(*Endpoint)
 
 func WithMetadata(key string, val ...string) EndpointOption {
@@ -60,18 +136,41 @@ func NewEndpoint(service, host string, port uint16, options ...EndpointOption) *
 	return endpoint
 }
 
+// Healthy checks the endpoint by the HealthyConfig, the clients of the check are not reused, see EndpointChecker.
 func (e *Endpoint) Healthy(ctx context.Context) error {
+	return e.check(ctx, nil)
+}
+
+// check is Healthy with the clients cached by the caller.
+func (e *Endpoint) check(ctx context.Context, clients *healthyClients) error {
 	if e.healthy == nil {
 		return nil
 	}
+	if timeout := e.healthy.Options.Get(HealthyOptionTimeout); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	p := e.healthy.Protocol.protocol()
+	if localOnly(p) && !e.healthy.local {
+		return fmt.Errorf("local-only protocol %s is not allowed without WithLocalCheck", p)
+	}
 	var err error
-	switch e.healthy.Protocol.protocol() {
+	switch p {
 	case HTTP:
-		err = HTTPHealthCheck(ctx, e.healthy)
+		err = httpHealthCheck(ctx, e.healthy, clients)
 	case GRPC:
-		err = GRPCHealthCheck(ctx, e.healthy, e.service, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		err = grpcHealthCheck(ctx, e.healthy, e.service, clients)
+	case TCP, Unix:
+		err = DialHealthCheck(ctx, string(p), e.healthy.Target)
+	case Exec:
+		err = ExecHealthCheck(ctx, e.healthy)
 	default:
-		return fmt.Errorf("unknown protocol: %v", e.healthy.Protocol.protocol())
+		return fmt.Errorf("unknown protocol: %v", p)
 	}
 	return err
 }
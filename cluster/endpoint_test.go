@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEndpointJSON(t *testing.T) {
+	endpoint := NewEndpoint("user-service", "10.0.0.1", 8080,
+		WithNodeID("node-001"),
+		WithMetadata("Zone", "cn-east-1a"),
+		WithHealthy(&HealthyConfig{Protocol: GRPC, Target: "10.0.0.1:8080"}),
+	)
+	data, err := json.Marshal(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Endpoint{}
+	if err = json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Address() != "10.0.0.1:8080" || decoded.NodeID() != "node-001" {
+		t.Errorf("unexpected endpoint: %s", data)
+	}
+	if decoded.Metadata().Get("zone") != "cn-east-1a" {
+		t.Errorf("unexpected metadata: %v", decoded.Metadata())
+	}
+	if !decoded.HealthyConfig().Protocol.Is(GRPC) {
+		t.Errorf("unexpected protocol: %v", decoded.HealthyConfig().Protocol)
+	}
+	if err = json.Unmarshal([]byte(`{"service":"user-service"}`), decoded); err == nil {
+		t.Error("node_id is required")
+	}
+
+	// the local-only checks are neither encoded nor decoded
+	local := NewEndpoint("user-service", "10.0.0.1", 8080, WithNodeID("node-001"),
+		WithHealthy(NewHealthyConfig(Exec, "/bin/true", WithLocalCheck())))
+	data, _ = json.Marshal(local)
+	decoded = &Endpoint{}
+	if err = json.Unmarshal(data, decoded); err != nil || decoded.HealthyConfig() != nil {
+		t.Errorf("unexpected endpoint: %s %v", data, err)
+	}
+	for _, name := range []string{"exec", "unix"} {
+		if _, err = ParseProtocol(name); err == nil {
+			t.Errorf("%s is parsed", name)
+		}
+	}
+	data = []byte(`{"service":"user-service","node_id":"node-001","healthy":{"protocol":"exec","target":"/bin/sh"}}`)
+	if err = json.Unmarshal(data, decoded); err == nil {
+		t.Error("exec is decoded")
+	}
+}
@@ -0,0 +1,389 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthCheckerFunc is an adapter to use a function as HealthChecker.
+type HealthCheckerFunc func(ctx context.Context) error
+
+func (f HealthCheckerFunc) Healthy(ctx context.Context) error { return f(ctx) }
+
+type Criticality int
+
+const (
+	// Optional failures are reported, but they don't change the status.
+	Optional Criticality = iota
+	// Critical failures make the service not ready, e.g. the database is unavailable.
+	Critical
+	// Vital failures make the service neither ready nor alive, the process should be restarted, e.g. a deadlock.
+	Vital
+)
+
+func (c Criticality) String() string {
+	switch c {
+	case Optional:
+		return "optional"
+	case Critical:
+		return "critical"
+	case Vital:
+		return "vital"
+	default:
+		return "unknown"
+	}
+}
+
+func (c Criticality) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultHealthTimeout  = 3 * time.Second
+)
+
+// CheckResult is the last result of a registered checker.
+type CheckResult struct {
+	Healthy     bool          `json:"healthy"`
+	Error       string        `json:"error,omitempty"`
+	Criticality Criticality   `json:"criticality"`
+	Duration    time.Duration `json:"duration_ns"`
+	CheckedAt   time.Time     `json:"checked_at"`
+}
+
+// HealthReport is the JSON body of the health endpoints.
+type HealthReport struct {
+	// Status is "ok", "degraded" if only optional checks failed, or "fail".
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type healthCheck struct {
+	name        string
+	checker     HealthChecker
+	criticality Criticality
+	timeout     time.Duration
+	interval    time.Duration
+	services    []string
+
+	// result is guarded by the mutex of HealthRegistry.
+	result CheckResult
+}
+
+type CheckOption func(*healthCheck)
+
+func WithCriticality(criticality Criticality) CheckOption {
+	return func(c *healthCheck) {
+		c.criticality = criticality
+	}
+}
+
+func WithCheckTimeout(timeout time.Duration) CheckOption {
+	return func(c *healthCheck) {
+		c.timeout = timeout
+	}
+}
+
+func WithCheckInterval(interval time.Duration) CheckOption {
+	return func(c *healthCheck) {
+		c.interval = interval
+	}
+}
+
+// WithCheckServices limits the checker to the grpc services, the checker applies to all the services by default.
+func WithCheckServices(services ...string) CheckOption {
+	return func(c *healthCheck) {
+		c.services = append(c.services, services...)
+	}
+}
+
+// HealthRegistry aggregates the named checkers. The checkers are run in background by Start,
+// and the cached results are served by the http handlers and the grpc health server.
+//
+//	health := cluster.NewHealthRegistry()
+//	health.Register("redis", cluster.HealthCheckerFunc(func(ctx context.Context) error {
+//		return rdb.Ping(ctx).Err()
+//	}), cluster.WithCriticality(cluster.Critical))
+//	health.Start(ctx)
+//	mux.Handle("/healthz", health.LivenessHandler())
+//	mux.Handle("/readyz", health.ReadinessHandler())
+//	grpc_health_v1.RegisterHealthServer(server, health.GRPCServer())
+type HealthRegistry struct {
+	interval time.Duration
+	timeout  time.Duration
+	services []string
+
+	mu       sync.RWMutex
+	checks   []*healthCheck
+	shutdown bool
+	// changed is closed and replaced when any result changes.
+	changed chan struct{}
+}
+
+type HealthRegistryOption func(*HealthRegistry)
+
+// WithHealthInterval set the default interval of the checkers.
+func WithHealthInterval(interval time.Duration) HealthRegistryOption {
+	return func(h *HealthRegistry) {
+		h.interval = interval
+	}
+}
+
+// WithHealthTimeout set the default timeout of the checkers.
+func WithHealthTimeout(timeout time.Duration) HealthRegistryOption {
+	return func(h *HealthRegistry) {
+		h.timeout = timeout
+	}
+}
+
+// WithHealthServices declares the grpc services, so the services without specific checkers are known.
+func WithHealthServices(services ...string) HealthRegistryOption {
+	return func(h *HealthRegistry) {
+		h.services = append(h.services, services...)
+	}
+}
+
+func NewHealthRegistry(options ...HealthRegistryOption) *HealthRegistry {
+	h := &HealthRegistry{
+		interval: defaultHealthInterval,
+		timeout:  defaultHealthTimeout,
+		changed:  make(chan struct{}),
+	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+// Register adds the checker, it must be called before Start.
+// The checker is failing until it's run, the criticality is Critical by default.
+func (h *HealthRegistry) Register(name string, checker HealthChecker, options ...CheckOption) {
+	c := &healthCheck{
+		name:        name,
+		checker:     checker,
+		criticality: Critical,
+		timeout:     h.timeout,
+		interval:    h.interval,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.result = CheckResult{Error: "not checked yet", Criticality: c.criticality}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, c)
+}
+
+// CheckAll runs all the checkers concurrently and waits for the results.
+func (h *HealthRegistry) CheckAll(ctx context.Context) {
+	h.mu.RLock()
+	checks := slices.Clone(h.checks)
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c *healthCheck) {
+			defer wg.Done()
+			h.run(ctx, c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// Start runs all the checkers once, then runs each checker on its interval in background until ctx is done.
+func (h *HealthRegistry) Start(ctx context.Context) {
+	h.CheckAll(ctx)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, c := range h.checks {
+		go h.loop(ctx, c)
+	}
+}
+
+func (h *HealthRegistry) loop(ctx context.Context, c *healthCheck) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.run(ctx, c)
+		}
+	}
+}
+
+func (h *HealthRegistry) run(ctx context.Context, c *healthCheck) {
+	start := time.Now()
+	checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	err := c.checker.Healthy(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	result := CheckResult{Healthy: err == nil, Criticality: c.criticality, Duration: time.Since(start), CheckedAt: start}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	changed := c.result.Healthy != result.Healthy
+	c.result = result
+	if changed {
+		h.notify()
+	}
+}
+
+// notify must be called with the lock held.
+func (h *HealthRegistry) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Shutdown marks all the services not serving, it's called before the server stops gracefully.
+func (h *HealthRegistry) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.shutdown {
+		h.shutdown = true
+		h.notify()
+	}
+}
+
+// report returns the results of the checks, the checks below the criticality are ignored by the status.
+func (h *HealthRegistry) report(criticality Criticality) *HealthReport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	report := &HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(h.checks))}
+	for _, c := range h.checks {
+		report.Checks[c.name] = c.result
+		if c.result.Healthy {
+			continue
+		}
+		if c.criticality >= criticality {
+			report.Status = "fail"
+		} else if report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+	if h.shutdown && criticality != Vital {
+		report.Status = "fail"
+	}
+	return report
+}
+
+// Liveness returns the report whose status fails only if a Vital check failed.
+func (h *HealthRegistry) Liveness() *HealthReport { return h.report(Vital) }
+
+// Readiness returns the report whose status fails if a Critical or Vital check failed, or the registry is shut down.
+func (h *HealthRegistry) Readiness() *HealthReport { return h.report(Critical) }
+
+func serveHealthReport(w http.ResponseWriter, report *HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == "fail" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// LivenessHandler serves the Liveness report, it responds 503 if the status fails, e.g. on /healthz.
+func (h *HealthRegistry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveHealthReport(w, h.Liveness())
+	})
+}
+
+// ReadinessHandler serves the Readiness report, it responds 503 if the status fails, e.g. on /readyz.
+func (h *HealthRegistry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveHealthReport(w, h.Readiness())
+	})
+}
+
+// servingStatus returns the grpc status of the service, the empty service is the whole server.
+func (h *HealthRegistry) servingStatus(service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, <-chan struct{}) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	known := service == "" || slices.Contains(h.services, service)
+	serving := !h.shutdown
+	for _, c := range h.checks {
+		applied := service == "" || len(c.services) == 0 || slices.Contains(c.services, service)
+		known = known || slices.Contains(c.services, service)
+		if applied && c.criticality >= Critical && !c.result.Healthy {
+			serving = false
+		}
+	}
+	switch {
+	case !known:
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, h.changed
+	case serving:
+		return grpc_health_v1.HealthCheckResponse_SERVING, h.changed
+	default:
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, h.changed
+	}
+}
+
+// Services returns the known grpc services.
+func (h *HealthRegistry) Services() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	services := slices.Clone(h.services)
+	for _, c := range h.checks {
+		for _, service := range c.services {
+			if !slices.Contains(services, service) {
+				services = append(services, service)
+			}
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// GRPCServer returns the grpc_health_v1.HealthServer reporting the status of each service.
+func (h *HealthRegistry) GRPCServer() grpc_health_v1.HealthServer {
+	return &healthServer{registry: h}
+}
+
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	registry *HealthRegistry
+}
+
+func (s *healthServer) Check(_ context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	serving, _ := s.registry.servingStatus(req.GetService())
+	if serving == grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: serving}, nil
+}
+
+func (s *healthServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	last := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+	for {
+		serving, changed := s.registry.servingStatus(req.GetService())
+		if serving != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: serving}); err != nil {
+				return err
+			}
+			last = serving
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "stream has ended")
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var redisDown atomic.Bool
+	redisDown.Store(true)
+	health := NewHealthRegistry(WithHealthServices("user.UserService"))
+	health.Register("redis", HealthCheckerFunc(func(ctx context.Context) error {
+		if redisDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}))
+	health.Register("search", HealthCheckerFunc(func(ctx context.Context) error {
+		return errors.New("timeout")
+	}), WithCriticality(Optional), WithCheckServices("search.SearchService"))
+	health.Start(ctx)
+
+	serve := func(handler http.Handler) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+	if code := serve(health.ReadinessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness: %d", code)
+	}
+	if code := serve(health.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("liveness: %d", code)
+	}
+
+	server := health.GRPCServer()
+	check := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := server.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		if err != nil {
+			return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		return resp.Status
+	}
+	if s := check("user.UserService"); s != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("user.UserService: %v", s)
+	}
+
+	redisDown.Store(false)
+	health.CheckAll(ctx)
+	if report := health.Readiness(); report.Status != "degraded" {
+		t.Errorf("readiness: %s", report.Status)
+	}
+	if s := check("user.UserService"); s != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Errorf("user.UserService: %v", s)
+	}
+	if s := check("search.SearchService"); s != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Errorf("search.SearchService: %v", s)
+	}
+	if s := check("unknown.Service"); s != grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN {
+		t.Errorf("unknown.Service: %v", s)
+	}
+
+	health.Shutdown()
+	if s := check(""); s != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("server: %v", s)
+	}
+}
@@ -1,11 +1,25 @@
 package cluster
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
@@ -41,12 +55,74 @@ func (p protocol) Is(v protocol) bool {
 const (
 	HTTP protocol = "http"
 	GRPC protocol = "grpc"
+	// TCP checks the Target (host:port) can be connected.
+	TCP protocol = "tcp"
+	// Unix checks the unix socket of the Target (path) can be connected.
+	// It's local-only, see WithLocalCheck.
+	Unix protocol = "unix"
+	// Exec runs the Target command with the HealthyOptionArgs, it's healthy if the command exits with 0.
+	// It's local-only, see WithLocalCheck.
+	Exec protocol = "exec"
 )
 
+// protocols are the protocols can be decoded from the config, the local-only ones are excluded,
+// otherwise whoever can write the registry can make every consumer run any command.
+var protocols = map[protocol]Protocol{
+	HTTP: HTTP,
+	GRPC: GRPC,
+	TCP:  TCP,
+}
+
+func localOnly(p protocol) bool {
+	return p == Unix || p == Exec
+}
+
+// ParseProtocol returns the defined Protocol of the name, it's used to decode the config.
+// The local-only protocols Unix and Exec can't be parsed.
+func ParseProtocol(name string) (Protocol, error) {
+	if p, ok := protocols[protocol(strings.ToLower(name))]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown protocol: %s", name)
+}
+
 type HealthyConfig struct {
 	Protocol Protocol
 	Target   string
 	Options  Metadata
+
+	// local allows the local-only protocols, it's never decoded from the config.
+	local bool
+}
+
+type healthyConfigJSON struct {
+	Protocol string   `json:"protocol"`
+	Target   string   `json:"target"`
+	Options  Metadata `json:"options,omitempty"`
+}
+
+func (c *HealthyConfig) MarshalJSON() ([]byte, error) {
+	var name string
+	if c.Protocol != nil {
+		name = string(c.Protocol.protocol())
+	}
+	return json.Marshal(&healthyConfigJSON{Protocol: name, Target: c.Target, Options: c.Options})
+}
+
+func (c *HealthyConfig) UnmarshalJSON(data []byte) error {
+	var v healthyConfigJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p, err := ParseProtocol(v.Protocol)
+	if err != nil {
+		return err
+	}
+	if v.Options == nil {
+		v.Options = Metadata{}
+	}
+	c.Protocol, c.Target, c.Options = p, v.Target, v.Options
+	return nil
 }
 
 type HealthChecker interface {
@@ -61,6 +137,14 @@ func WithHealthyConfigOptions(key string, val ...string) HealthyConfigOption {
 	}
 }
 
+// WithLocalCheck allows the local-only protocols Unix and Exec in Endpoint.Healthy. The config is not encoded
+// into the registry since the check can't be done by the other instances.
+func WithLocalCheck() HealthyConfigOption {
+	return func(config *HealthyConfig) {
+		config.local = true
+	}
+}
+
 func NewHealthyConfig(protocol Protocol, target string, options ...HealthyConfigOption) *HealthyConfig {
 	c := &HealthyConfig{
 		Protocol: protocol,
@@ -73,8 +157,392 @@ func NewHealthyConfig(protocol Protocol, target string, options ...HealthyConfig
 	return c
 }
 
+// the keys of HealthyConfig.Options.
+const (
+	// HealthyOptionMethod is the method of HTTP, GET by default.
+	HealthyOptionMethod = "method"
+	// HealthyOptionHeader is a request header of HTTP in the format "Name: value", multiple values are allowed.
+	HealthyOptionHeader = "header"
+	// HealthyOptionStatus is the expected status of HTTP, a code (200) or a range (200-299), multiple values are allowed.
+	// The default is 200.
+	HealthyOptionStatus = "status"
+	// HealthyOptionBodyContains is a substring the body of HTTP must contain.
+	HealthyOptionBodyContains = "body_contains"
+	// HealthyOptionJSONPath is a dotted path in the JSON body of HTTP, e.g. "checks.db.status" or "items.0.id",
+	// the value must exist and equal to HealthyOptionJSONValue if it's set.
+	HealthyOptionJSONPath  = "json_path"
+	HealthyOptionJSONValue = "json_value"
+
+	// HealthyOptionTLSCA is the file of the CA certificates verifying the server.
+	HealthyOptionTLSCA = "tls_ca"
+	// HealthyOptionTLSCert and HealthyOptionTLSKey are the files of the client certificate.
+	HealthyOptionTLSCert = "tls_cert"
+	HealthyOptionTLSKey  = "tls_key"
+	// HealthyOptionTLSServerName overrides the server name verified.
+	HealthyOptionTLSServerName = "tls_server_name"
+	// HealthyOptionTLSInsecure skips the verification of the server if it's "true".
+	HealthyOptionTLSInsecure = "tls_insecure_skip_verify"
+
+	// HealthyOptionArgs is the arguments of Exec, one value per argument.
+	HealthyOptionArgs = "args"
+
+	// HealthyOptionService is the service checked by GRPC, the service of the endpoint by default,
+	// set it empty to check the whole server.
+	HealthyOptionService = "service"
+
+	// HealthyOptionTimeout is the timeout of each check of Endpoint.Healthy, e.g. 500ms.
+	HealthyOptionTimeout = "timeout"
+)
+
+const maxHealthyBodySize = 1 << 20
+
+// tlsConfig builds the tls config by the options, nil is returned if there is no tls option.
+func tlsConfig(options Metadata) (*tls.Config, error) {
+	ca, cert, key := options.Get(HealthyOptionTLSCA), options.Get(HealthyOptionTLSCert), options.Get(HealthyOptionTLSKey)
+	serverName, insecure := options.Get(HealthyOptionTLSServerName), options.Get(HealthyOptionTLSInsecure) == "true"
+	if ca == "" && cert == "" && key == "" && serverName == "" && !insecure {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: serverName, InsecureSkipVerify: insecure}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", ca)
+		}
+	}
+	if cert != "" || key != "" {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// healthyClients caches the http clients and the grpc connections of the health checks by the targets and the tls
+// options, so the connections are reused between the checks of the owner, e.g. a Transport or an EndpointChecker.
+// The entries are reference counted: the owner retains the entry of an endpoint while it's known and releases
+// it when it's removed, and each check holds the entry until it's done, so an entry is closed only after the last
+// endpoint sharing it is released and the checks in flight are finished. If retained is false, the entries are
+// created by the checks and kept until release or close, otherwise only the retained ones are cached.
+// A nil *healthyClients caches nothing, the clients are closed after each check.
+type healthyClients struct {
+	mu       sync.Mutex
+	closed   bool
+	retained bool
+	entries  map[string]*healthyClientEntry
+}
+
+type healthyClientEntry struct {
+	http *http.Client
+	grpc *grpc.ClientConn
+	// owners is the number of the endpoints retaining the entry, inflight is the number of the checks using it.
+	owners   int
+	inflight int
+	removed  bool
+}
+
+func (e *healthyClientEntry) close() {
+	if e.http != nil {
+		e.http.CloseIdleConnections()
+	}
+	if e.grpc != nil {
+		_ = e.grpc.Close()
+	}
+}
+
+func newHealthyClients() *healthyClients {
+	return &healthyClients{entries: map[string]*healthyClientEntry{}}
+}
+
+func newRetainedHealthyClients() *healthyClients {
+	return &healthyClients{retained: true, entries: map[string]*healthyClientEntry{}}
+}
+
+var errHealthyClientsClosed = errors.New("health check clients are closed")
+
+func healthyClientKey(healthyConfig *HealthyConfig) string {
+	options := healthyConfig.Options
+	return strings.Join([]string{
+		healthyConfig.Target,
+		options.Get(HealthyOptionTLSCA), options.Get(HealthyOptionTLSCert), options.Get(HealthyOptionTLSKey),
+		options.Get(HealthyOptionTLSServerName), options.Get(HealthyOptionTLSInsecure),
+	}, "\x00")
+}
+
+// entry returns the entry of the config, nil if it's not cached, it must be called with mu held.
+func (c *healthyClients) entry(healthyConfig *HealthyConfig, create bool) (*healthyClientEntry, error) {
+	if c.closed {
+		return nil, errHealthyClientsClosed
+	}
+	key := healthyClientKey(healthyConfig)
+	entry, ok := c.entries[key]
+	if !ok && create {
+		entry = &healthyClientEntry{}
+		c.entries[key] = entry
+	}
+	return entry, nil
+}
+
+// done is returned as the release of a check, the entry removed while in use is closed by the last check.
+func (c *healthyClients) done(entry *healthyClientEntry) func() {
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if entry.inflight--; entry.inflight == 0 && entry.removed {
+			entry.close()
+		}
+	}
+}
+
+func newHealthyHTTPClient(options Metadata) (*http.Client, error) {
+	config, err := tlsConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+// httpClient returns the client of the config, release is called after the check.
+func (c *healthyClients) httpClient(healthyConfig *HealthyConfig) (client *http.Client, release func(), err error) {
+	var entry *healthyClientEntry
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if entry, err = c.entry(healthyConfig, !c.retained); err != nil {
+			return nil, nil, err
+		}
+	}
+	if entry == nil {
+		client, err = newHealthyHTTPClient(healthyConfig.Options)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, client.CloseIdleConnections, nil
+	}
+	if entry.http == nil {
+		if entry.http, err = newHealthyHTTPClient(healthyConfig.Options); err != nil {
+			return nil, nil, err
+		}
+	}
+	entry.inflight++
+	return entry.http, c.done(entry), nil
+}
+
+func newHealthyGRPCConn(healthyConfig *HealthyConfig, options ...grpc.DialOption) (*grpc.ClientConn, error) {
+	config, err := tlsConfig(healthyConfig.Options)
+	if err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if config != nil {
+		creds = credentials.NewTLS(config)
+	}
+	return grpc.NewClient(healthyConfig.Target, append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, options...)...)
+}
+
+// grpcConn returns the connection of the config, release is called after the check.
+func (c *healthyClients) grpcConn(healthyConfig *HealthyConfig) (cc *grpc.ClientConn, release func(), err error) {
+	var entry *healthyClientEntry
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if entry, err = c.entry(healthyConfig, !c.retained); err != nil {
+			return nil, nil, err
+		}
+	}
+	if entry == nil {
+		cc, err = newHealthyGRPCConn(healthyConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cc, func() { _ = cc.Close() }, nil
+	}
+	if entry.grpc == nil {
+		if entry.grpc, err = newHealthyGRPCConn(healthyConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+	entry.inflight++
+	return entry.grpc, c.done(entry), nil
+}
+
+// retain adds an owner of the clients of the config, which are released by release.
+func (c *healthyClients) retain(healthyConfig *HealthyConfig) {
+	if c == nil || healthyConfig == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, err := c.entry(healthyConfig, true); err == nil {
+		entry.owners++
+	}
+}
+
+// release removes an owner of the clients of the config, they are closed after the last owner
+// once the checks in flight are done.
+func (c *healthyClients) release(healthyConfig *HealthyConfig) {
+	if c == nil || healthyConfig == nil {
+		return
+	}
+	key := healthyClientKey(healthyConfig)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if entry.owners--; entry.owners > 0 {
+		return
+	}
+	delete(c.entries, key)
+	c.remove(entry)
+}
+
+// remove closes the entry, or defers it to the last check in flight, it must be called with mu held.
+func (c *healthyClients) remove(entry *healthyClientEntry) {
+	entry.removed = true
+	if entry.inflight == 0 {
+		entry.close()
+	}
+}
+
+func (c *healthyClients) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for key, entry := range c.entries {
+		delete(c.entries, key)
+		c.remove(entry)
+	}
+}
+
+// EndpointChecker checks the endpoints with the http clients and the grpc connections reused between the checks,
+// unlike Endpoint.Healthy, HTTPHealthCheck and GRPCHealthCheck which create them for each check.
+// The clients are kept until Release or Close.
+type EndpointChecker struct {
+	clients *healthyClients
+}
+
+func NewEndpointChecker() *EndpointChecker {
+	return &EndpointChecker{clients: newHealthyClients()}
+}
+
+// Check checks the endpoint by its HealthyConfig, see Endpoint.Healthy.
+func (c *EndpointChecker) Check(ctx context.Context, endpoint *Endpoint) error {
+	return endpoint.check(ctx, c.clients)
+}
+
+// Release closes the clients of the config after the checks in flight are done.
+func (c *EndpointChecker) Release(healthyConfig *HealthyConfig) {
+	c.clients.release(healthyConfig)
+}
+
+// Close closes all the clients, the checks after Close fail.
+func (c *EndpointChecker) Close() error {
+	c.clients.close()
+	return nil
+}
+
+// statusMatched reports whether the code matches any of the expected codes or ranges.
+func statusMatched(code int, expected []string) (bool, error) {
+	if len(expected) == 0 {
+		return code == http.StatusOK, nil
+	}
+	for _, e := range expected {
+		low, high, isRange := strings.Cut(strings.TrimSpace(e), "-")
+		if !isRange {
+			high = low
+		}
+		from, err := strconv.Atoi(strings.TrimSpace(low))
+		if err != nil {
+			return false, fmt.Errorf("invalid status %q", e)
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(high))
+		if err != nil {
+			return false, fmt.Errorf("invalid status %q", e)
+		}
+		if code >= from && code <= to {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// jsonPath returns the value of the dotted path in the JSON document.
+func jsonPath(document any, path string) (any, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return document, true
+	}
+	for _, field := range strings.Split(path, ".") {
+		switch v := document.(type) {
+		case map[string]any:
+			value, ok := v[field]
+			if !ok {
+				return nil, false
+			}
+			document = value
+		case []any:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			document = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return document, true
+}
+
+func assertHTTPBody(body []byte, options Metadata) error {
+	if substr := options.Get(HealthyOptionBodyContains); substr != "" && !bytes.Contains(body, []byte(substr)) {
+		return fmt.Errorf("body does not contain %q", substr)
+	}
+	path := options.Get(HealthyOptionJSONPath)
+	if path == "" {
+		return nil
+	}
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		return fmt.Errorf("invalid json body: %w", err)
+	}
+	value, ok := jsonPath(document, path)
+	if !ok {
+		return fmt.Errorf("json path %s not found", path)
+	}
+	if expected, ok := options[HealthyOptionJSONValue]; ok && len(expected) > 0 {
+		var actual string
+		if s, isString := value.(string); isString {
+			actual = s
+		} else {
+			data, _ := json.Marshal(value)
+			actual = string(data)
+		}
+		if actual != expected[0] {
+			return fmt.Errorf("json path %s is %s, expected %s", path, actual, expected[0])
+		}
+	}
+	return nil
+}
+
+// HTTPHealthCheck checks the Target url, the client is created for the check and closed after it,
+// use EndpointChecker to reuse the clients.
 func HTTPHealthCheck(ctx context.Context, healthyConfig *HealthyConfig) error {
-	method := healthyConfig.Options.Get("method")
+	return httpHealthCheck(ctx, healthyConfig, nil)
+}
+
+func httpHealthCheck(ctx context.Context, healthyConfig *HealthyConfig, clients *healthyClients) error {
+	options := healthyConfig.Options
+	method := options.Get(HealthyOptionMethod)
 	if method == "" {
 		method = http.MethodGet
 	}
@@ -82,25 +550,96 @@ func HTTPHealthCheck(ctx context.Context, healthyConfig *HealthyConfig) error {
 	if err != nil {
 		return err
 	}
-	response, err := http.DefaultClient.Do(request)
+	for _, header := range options.Gets(HealthyOptionHeader) {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q", header)
+		}
+		request.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	client, release, err := clients.httpClient(healthyConfig)
+	if err != nil {
+		return err
+	}
+	defer release()
+	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
-	if response.StatusCode != http.StatusOK {
+	matched, err := statusMatched(response.StatusCode, options.Gets(HealthyOptionStatus))
+	if err != nil {
+		return err
+	}
+	if !matched {
 		return errors.New(response.Status)
 	}
+	if options.Get(HealthyOptionBodyContains) == "" && options.Get(HealthyOptionJSONPath) == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxHealthyBodySize))
+	if err != nil {
+		return err
+	}
+	return assertHTTPBody(body, options)
+}
+
+// DialHealthCheck checks the address can be connected by the network, e.g. tcp or unix.
+func DialHealthCheck(ctx context.Context, network, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// ExecHealthCheck runs the Target command, the output is returned in the error if the command failed.
+func ExecHealthCheck(ctx context.Context, healthyConfig *HealthyConfig) error {
+	output, err := exec.CommandContext(ctx, healthyConfig.Target, healthyConfig.Options.Gets(HealthyOptionArgs)...).CombinedOutput()
+	if err != nil {
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return err
+	}
 	return nil
 }
 
+// GRPCHealthCheck checks the service by grpc_health_v1, HealthyOptionService overrides the service.
+// The connection of the target is created by grpc.NewClient for the check and closed after it, use EndpointChecker
+// to reuse the connections. The transport credentials are built from the tls options, insecure if there is none.
+// The options override the credentials.
 func GRPCHealthCheck(ctx context.Context, healthyConfig *HealthyConfig, service string, options ...grpc.DialOption) error {
-	cc, err := grpc.DialContext(ctx, healthyConfig.Target, options...)
+	cc, err := newHealthyGRPCConn(healthyConfig, options...)
 	if err != nil {
 		return err
 	}
 	defer cc.Close()
+	return checkGRPCHealth(ctx, cc, healthyConfig, service)
+}
+
+func grpcHealthCheck(ctx context.Context, healthyConfig *HealthyConfig, service string, clients *healthyClients) error {
+	cc, release, err := clients.grpcConn(healthyConfig)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return checkGRPCHealth(ctx, cc, healthyConfig, service)
+}
+
+// grpcHealthService returns the service checked of the endpoint service.
+func grpcHealthService(healthyConfig *HealthyConfig, service string) string {
+	if s, ok := healthyConfig.Options[HealthyOptionService]; ok && len(s) > 0 {
+		return s[0]
+	}
+	return service
+}
+
+func checkGRPCHealth(ctx context.Context, cc *grpc.ClientConn, healthyConfig *HealthyConfig, service string) error {
 	client := grpc_health_v1.NewHealthClient(cc)
-	response, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	request := &grpc_health_v1.HealthCheckRequest{Service: grpcHealthService(healthyConfig, service)}
+	response, err := client.Check(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Probe") != "1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"status":"up","checks":[{"name":"db","latency":3}]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	cases := []struct {
+		options []HealthyConfigOption
+		healthy bool
+	}{
+		{options: nil, healthy: false},
+		{options: []HealthyConfigOption{WithHealthyConfigOptions(HealthyOptionHeader, "X-Probe: 1")}, healthy: false},
+		{options: []HealthyConfigOption{
+			WithHealthyConfigOptions(HealthyOptionHeader, "X-Probe: 1"),
+			WithHealthyConfigOptions(HealthyOptionStatus, "200-299"),
+			WithHealthyConfigOptions(HealthyOptionBodyContains, `"up"`),
+			WithHealthyConfigOptions(HealthyOptionJSONPath, "checks.0.latency"),
+			WithHealthyConfigOptions(HealthyOptionJSONValue, "3"),
+		}, healthy: true},
+		{options: []HealthyConfigOption{
+			WithHealthyConfigOptions(HealthyOptionHeader, "X-Probe: 1"),
+			WithHealthyConfigOptions(HealthyOptionStatus, "202"),
+			WithHealthyConfigOptions(HealthyOptionJSONPath, "$.status"),
+			WithHealthyConfigOptions(HealthyOptionJSONValue, "down"),
+		}, healthy: false},
+		{options: []HealthyConfigOption{WithHealthyConfigOptions(HealthyOptionStatus, "400-499")}, healthy: true},
+	}
+	for i, c := range cases {
+		err := HTTPHealthCheck(ctx, NewHealthyConfig(HTTP, server.URL, c.options...))
+		if (err == nil) != c.healthy {
+			t.Errorf("case %d: %v", i, err)
+		}
+	}
+}
+
+func TestDialAndExecHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := NewEndpoint("user-service", "127.0.0.1", 0, WithHealthy(NewHealthyConfig(TCP, lis.Addr().String())))
+	if err = endpoint.Healthy(ctx); err != nil {
+		t.Error(err)
+	}
+	_ = lis.Close()
+	if err = endpoint.Healthy(ctx); err == nil {
+		t.Error("closed listener is healthy")
+	}
+
+	if err = ExecHealthCheck(ctx, NewHealthyConfig(Exec, "sh", WithHealthyConfigOptions(HealthyOptionArgs, "-c", "exit 0"))); err != nil {
+		t.Error(err)
+	}
+	if err = ExecHealthCheck(ctx, NewHealthyConfig(Exec, "sh", WithHealthyConfigOptions(HealthyOptionArgs, "-c", "echo broken; exit 1"))); err == nil {
+		t.Error("failed command is healthy")
+	}
+
+	// the local-only protocols require WithLocalCheck
+	endpoint = NewEndpoint("user-service", "127.0.0.1", 0, WithHealthy(NewHealthyConfig(Exec, "sh",
+		WithHealthyConfigOptions(HealthyOptionArgs, "-c", "exit 0"))))
+	if err = endpoint.Healthy(ctx); err == nil {
+		t.Error("exec is allowed without WithLocalCheck")
+	}
+	WithLocalCheck()(endpoint.HealthyConfig())
+	if err = endpoint.Healthy(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	host, port := startHealthServer(t)
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	endpoint := NewEndpoint("user-service", host, port, WithHealthy(NewHealthyConfig(GRPC, target,
+		WithHealthyConfigOptions(HealthyOptionTimeout, "1s"),
+	)))
+	if err := endpoint.Healthy(ctx); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown service: %v", err)
+	}
+	endpoint.HealthyConfig().Options.Set(HealthyOptionService, "")
+
+	// the connection is reused by the checks of the same checker
+	checker := NewEndpointChecker()
+	for i := 0; i < 2; i++ {
+		if err := checker.Check(ctx, endpoint); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(checker.clients.entries) != 1 {
+		t.Errorf("%d connections are created", len(checker.clients.entries))
+	}
+	checker.Release(endpoint.HealthyConfig())
+	if len(checker.clients.entries) != 0 {
+		t.Errorf("%d connections are not released", len(checker.clients.entries))
+	}
+	_ = checker.Close()
+	if err := checker.Check(ctx, endpoint); !errors.Is(err, errHealthyClientsClosed) {
+		t.Errorf("check after close: %v", err)
+	}
+
+	// the dial options are applied
+	var intercepted bool
+	err := GRPCHealthCheck(ctx, endpoint.HealthyConfig(), "", grpc.WithUnaryInterceptor(
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			intercepted = true
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}))
+	if err != nil || !intercepted {
+		t.Errorf("dial options are not applied: %v", err)
+	}
+}
+
+func TestHealthyClientsRelease(t *testing.T) {
+	clients := newRetainedHealthyClients()
+	defer clients.close()
+	healthy := NewHealthyConfig(GRPC, "127.0.0.1:8080")
+
+	// the connections of the unretained configs are not cached
+	cc, release, err := clients.grpcConn(healthy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if len(clients.entries) != 0 || cc.GetState() != connectivity.Shutdown {
+		t.Error("the connection of the unretained config is cached")
+	}
+
+	// the endpoints sharing the config don't close the connection of each other
+	clients.retain(healthy)
+	clients.retain(NewHealthyConfig(GRPC, "127.0.0.1:8080"))
+	cc, release, err = clients.grpcConn(healthy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	clients.release(healthy)
+	if cc.GetState() == connectivity.Shutdown {
+		t.Error("the connection is closed while it's retained")
+	}
+
+	// the connection is closed after the check in flight
+	cc, release, err = clients.grpcConn(healthy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients.release(healthy)
+	if cc.GetState() == connectivity.Shutdown {
+		t.Error("the connection is closed during the check")
+	}
+	release()
+	if len(clients.entries) != 0 || cc.GetState() != connectivity.Shutdown {
+		t.Error("the released connection is not closed")
+	}
+}
@@ -0,0 +1,225 @@
+package cluster
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// MetadataStatus is the metadata key of the serving status of the endpoint, set by Lifecycle.
+// The endpoints with a status other than StatusServing are skipped by the resolver and the Transport.
+const MetadataStatus = "status"
+
+const (
+	StatusServing   = "serving"
+	StatusUnhealthy = "unhealthy"
+	StatusDraining  = "draining"
+)
+
+// Status returns the serving status of the endpoint, StatusServing if it's not set.
+func (e *Endpoint) Status() string {
+	if status := e.metadata.Get(MetadataStatus); status != "" {
+		return status
+	}
+	return StatusServing
+}
+
+func (e *Endpoint) Serving() bool {
+	return e.Status() == StatusServing
+}
+
+// withStatus returns a copy of the endpoint with the status.
+func (e *Endpoint) withStatus(status string) *Endpoint {
+	endpoint := *e
+	endpoint.metadata = make(Metadata, len(e.metadata)+1)
+	for key, values := range e.metadata {
+		endpoint.metadata[key] = slices.Clone(values)
+	}
+	endpoint.metadata.Set(MetadataStatus, status)
+	return &endpoint
+}
+
+const (
+	defaultLifecycleInterval         = 10 * time.Second
+	defaultLifecycleTimeout          = 3 * time.Second
+	defaultLifecycleFailureThreshold = 3
+	defaultLifecycleDrainTimeout     = 5 * time.Second
+)
+
+// Lifecycle registers an endpoint and keeps its status in the registry.
+//
+// The endpoint is checked by Endpoint.Healthy every interval, and is marked StatusUnhealthy after the failure
+// threshold is reached, StatusServing again after a success. The endpoint is registered again in the next interval
+// if the registry failed, or it's missing from the registry implementing Discovery (e.g. the registry data is lost).
+//
+// When the context is cancelled, the endpoint is marked StatusDraining, and deregistered after the drain timeout,
+// so the clients stop sending new requests before the server stops:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+//	defer stop()
+//	go server.Serve(lis)
+//	if err := cluster.NewLifecycle(registry, endpoint).Run(ctx); err != nil {
+//		log.Println(err)
+//	}
+//	server.GracefulStop()
+type Lifecycle struct {
+	registry Registry
+	endpoint *Endpoint
+
+	interval         time.Duration
+	timeout          time.Duration
+	failureThreshold int
+	drainTimeout     time.Duration
+	onError          func(error)
+	onStatus         func(status string)
+}
+
+type LifecycleOption func(*Lifecycle)
+
+// WithLifecycleHealthCheck set the interval and the timeout of Endpoint.Healthy, the non-positive ones keep the default.
+func WithLifecycleHealthCheck(interval, timeout time.Duration) LifecycleOption {
+	return func(l *Lifecycle) {
+		if interval > 0 {
+			l.interval = interval
+		}
+		if timeout > 0 {
+			l.timeout = timeout
+		}
+	}
+}
+
+// WithFailureThreshold set how many consecutive failures mark the endpoint unhealthy.
+func WithFailureThreshold(n int) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.failureThreshold = n
+	}
+}
+
+// WithDrainTimeout set how long the endpoint is kept as draining before deregistered.
+func WithDrainTimeout(timeout time.Duration) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.drainTimeout = timeout
+	}
+}
+
+// WithLifecycleErrorHandler set the handler of the registry errors.
+func WithLifecycleErrorHandler(onError func(error)) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.onError = onError
+	}
+}
+
+// WithStatusHandler set the handler called when the status of the endpoint changes.
+func WithStatusHandler(onStatus func(status string)) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.onStatus = onStatus
+	}
+}
+
+func NewLifecycle(registry Registry, endpoint *Endpoint, options ...LifecycleOption) *Lifecycle {
+	l := &Lifecycle{
+		registry:         registry,
+		endpoint:         endpoint,
+		interval:         defaultLifecycleInterval,
+		timeout:          defaultLifecycleTimeout,
+		failureThreshold: defaultLifecycleFailureThreshold,
+		drainTimeout:     defaultLifecycleDrainTimeout,
+	}
+	for _, option := range options {
+		option(l)
+	}
+	return l
+}
+
+func (l *Lifecycle) error(err error) {
+	if err != nil && l.onError != nil {
+		l.onError(err)
+	}
+}
+
+// registered reports whether the endpoint with the status is found in the registry,
+// it's always true if the registry doesn't implement Discovery.
+func (l *Lifecycle) registered(ctx context.Context, status string) bool {
+	discovery, ok := l.registry.(Discovery)
+	if !ok {
+		return true
+	}
+	endpoints, err := discovery.GetService(ctx, l.endpoint.service)
+	if err != nil {
+		return true
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.nodeID == l.endpoint.nodeID {
+			return endpoint.Status() == status
+		}
+	}
+	return false
+}
+
+// Run registers the endpoint and blocks until the context is cancelled and the endpoint is deregistered,
+// an error is returned only if the first registration failed.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	status := StatusServing
+	if err := l.registry.Register(ctx, l.endpoint.withStatus(status)); err != nil {
+		return err
+	}
+	if l.onStatus != nil {
+		l.onStatus(status)
+	}
+
+	clients := newHealthyClients()
+	defer clients.close()
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	failures, dirty := 0, false
+	for {
+		select {
+		case <-ctx.Done():
+			l.stop()
+			return nil
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, l.timeout)
+		err := l.endpoint.check(checkCtx, clients)
+		cancel()
+		if ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		next := StatusServing
+		if failures >= l.failureThreshold {
+			next = StatusUnhealthy
+		}
+		if next != status && l.onStatus != nil {
+			l.onStatus(next)
+		}
+		if next != status || dirty || !l.registered(ctx, next) {
+			status = next
+			err = l.registry.Register(ctx, l.endpoint.withStatus(status))
+			dirty = err != nil
+			l.error(err)
+		}
+	}
+}
+
+// stop marks the endpoint draining and deregisters it after the drain timeout.
+func (l *Lifecycle) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.drainTimeout+l.timeout)
+	defer cancel()
+	if l.onStatus != nil {
+		l.onStatus(StatusDraining)
+	}
+	if l.drainTimeout > 0 {
+		if err := l.registry.Register(ctx, l.endpoint.withStatus(StatusDraining)); err != nil {
+			l.error(err)
+		} else {
+			time.Sleep(l.drainTimeout)
+		}
+	}
+	l.error(l.registry.Deregister(ctx, l.endpoint))
+}
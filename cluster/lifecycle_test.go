@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	registry := NewMemoryRegistry()
+	endpoint := NewEndpoint("user-service", "127.0.0.1", 8080, WithHealthy(NewHealthyConfig(HTTP, server.URL)))
+	statuses := make(chan string, 8)
+	lifecycle := NewLifecycle(registry, endpoint,
+		WithLifecycleHealthCheck(10*time.Millisecond, time.Second),
+		WithFailureThreshold(2),
+		WithDrainTimeout(10*time.Millisecond),
+		WithStatusHandler(func(status string) { statuses <- status }),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- lifecycle.Run(ctx) }()
+
+	expect := func(status string) {
+		t.Helper()
+		select {
+		case got := <-statuses:
+			if got != status {
+				t.Fatalf("expect status %s, got %s", status, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect status %s", status)
+		}
+	}
+	expect(StatusServing)
+	failing.Store(true)
+	expect(StatusUnhealthy)
+	failing.Store(false)
+	expect(StatusServing)
+
+	cancel()
+	expect(StatusDraining)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if endpoints, _ := registry.GetService(context.Background(), "user-service"); len(endpoints) != 0 {
+		t.Errorf("endpoint is not deregistered: %v", endpoints)
+	}
+}
+
+func TestLifecycleOptions(t *testing.T) {
+	lifecycle := NewLifecycle(NewMemoryRegistry(), NewEndpoint("user-service", "127.0.0.1", 8080),
+		WithLifecycleHealthCheck(0, -time.Second))
+	if lifecycle.interval != defaultLifecycleInterval || lifecycle.timeout != defaultLifecycleTimeout {
+		t.Errorf("unexpected interval %s and timeout %s", lifecycle.interval, lifecycle.timeout)
+	}
+}
@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRegistry is an in-process Registry and Discovery for tests and single-process deployments,
+// the endpoints are stored in their JSON format, so the registered and the discovered endpoints can be modified safely.
+type MemoryRegistry struct {
+	mu       sync.RWMutex
+	services map[string]map[string][]byte
+	watchers map[string]map[chan struct{}]struct{}
+
+	debounce time.Duration
+}
+
+type MemoryRegistryOption func(*MemoryRegistry)
+
+// WithMemoryRegistryDebounce set the debounce of Watch, the changes within it are sent together.
+func WithMemoryRegistryDebounce(debounce time.Duration) MemoryRegistryOption {
+	return func(r *MemoryRegistry) {
+		r.debounce = debounce
+	}
+}
+
+func NewMemoryRegistry(options ...MemoryRegistryOption) *MemoryRegistry {
+	r := &MemoryRegistry{
+		services: map[string]map[string][]byte{},
+		watchers: map[string]map[chan struct{}]struct{}{},
+		debounce: defaultWatchDebounce,
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func (r *MemoryRegistry) Register(_ context.Context, endpoint *Endpoint) error {
+	data, err := json.Marshal(endpoint)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nodes, ok := r.services[endpoint.service]
+	if !ok {
+		nodes = map[string][]byte{}
+		r.services[endpoint.service] = nodes
+	}
+	nodes[endpoint.nodeID] = data
+	r.notify(endpoint.service)
+	return nil
+}
+
+func (r *MemoryRegistry) Deregister(_ context.Context, endpoint *Endpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nodes, ok := r.services[endpoint.service]
+	if !ok {
+		return nil
+	}
+	delete(nodes, endpoint.nodeID)
+	if len(nodes) == 0 {
+		delete(r.services, endpoint.service)
+	}
+	r.notify(endpoint.service)
+	return nil
+}
+
+// notify must be called with the lock held.
+func (r *MemoryRegistry) notify(service string) {
+	for ch := range r.watchers[service] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// GetService returns the endpoints of the service ordered by node id.
+func (r *MemoryRegistry) GetService(_ context.Context, name string) ([]*Endpoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := r.services[name]
+	endpoints := make([]*Endpoint, 0, len(nodes))
+	for _, data := range nodes {
+		endpoint := &Endpoint{}
+		if err := json.Unmarshal(data, endpoint); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].nodeID < endpoints[j].nodeID })
+	return endpoints, nil
+}
+
+func (r *MemoryRegistry) Watch(ctx context.Context, name string) (<-chan Event, error) {
+	notify := make(chan struct{}, 1)
+	r.mu.Lock()
+	if r.watchers[name] == nil {
+		r.watchers[name] = map[chan struct{}]struct{}{}
+	}
+	r.watchers[name][notify] = struct{}{}
+	r.mu.Unlock()
+
+	w := &watcher{
+		load: func(ctx context.Context) ([]*Endpoint, error) {
+			return r.GetService(ctx, name)
+		},
+		debounce: r.debounce,
+	}
+	return w.start(ctx, notify, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.watchers[name], notify)
+		if len(r.watchers[name]) == 0 {
+			delete(r.watchers, name)
+		}
+	})
+}
@@ -1,6 +1,9 @@
 package cluster
 
-import "strings"
+import (
+	"slices"
+	"strings"
+)
 
 // Metadata
 // the key is case-insensitive
@@ -32,3 +35,14 @@ func (m Metadata) Gets(key string) []string {
 	key = strings.ToLower(key)
 	return m[key]
 }
+
+func (m Metadata) clone() Metadata {
+	if m == nil {
+		return nil
+	}
+	c := make(Metadata, len(m))
+	for key, values := range m {
+		c[key] = slices.Clone(values)
+	}
+	return c
+}
@@ -0,0 +1,249 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMonitorInterval = 5 * time.Second
+	defaultMonitorTimeout  = 3 * time.Second
+)
+
+// HealthEvent is a change of the health of the monitored endpoint.
+type HealthEvent struct {
+	Endpoint *Endpoint
+	Healthy  bool
+	// Err is the last failure if it's unhealthy.
+	Err error
+	At  time.Time
+}
+
+// HealthMonitor reports the health changes of an endpoint.
+//
+// The GRPC endpoints are watched by grpc_health_v1.Health/Watch, so NOT_SERVING is reported as soon as it's sent
+// by the server, the stream is reconnected after the interval if it's broken, and polling is used instead if the
+// server doesn't implement Watch. The other endpoints are polled by Endpoint.Healthy every interval.
+//
+// The changes are damped by the thresholds: an unhealthy endpoint becomes healthy after rise consecutive successes,
+// and a healthy one becomes unhealthy after fall consecutive failures, the status of a watch stream is counted
+// again every interval. The first result is reported immediately.
+type HealthMonitor struct {
+	endpoint *Endpoint
+	interval time.Duration
+	timeout  time.Duration
+	rise     int
+	fall     int
+	onChange func(HealthEvent)
+	// clients are the connections of the checks, they are closed when Run returns.
+	clients *healthyClients
+
+	mu        sync.RWMutex
+	known     bool
+	healthy   bool
+	successes int
+	failures  int
+}
+
+type HealthMonitorOption func(*HealthMonitor)
+
+// WithMonitorInterval set the interval of polling, counting the watched status and reconnecting the stream,
+// a non-positive interval keeps the default.
+func WithMonitorInterval(interval time.Duration) HealthMonitorOption {
+	return func(m *HealthMonitor) {
+		if interval > 0 {
+			m.interval = interval
+		}
+	}
+}
+
+// WithMonitorTimeout set the timeout of each polling, a non-positive timeout keeps the default.
+func WithMonitorTimeout(timeout time.Duration) HealthMonitorOption {
+	return func(m *HealthMonitor) {
+		if timeout > 0 {
+			m.timeout = timeout
+		}
+	}
+}
+
+// WithThresholds set the rise and fall thresholds, both are 1 by default.
+func WithThresholds(rise, fall int) HealthMonitorOption {
+	return func(m *HealthMonitor) {
+		m.rise = max(rise, 1)
+		m.fall = max(fall, 1)
+	}
+}
+
+// WithHealthChangeHandler set the handler of the changes, it's called synchronously by Run.
+func WithHealthChangeHandler(onChange func(HealthEvent)) HealthMonitorOption {
+	return func(m *HealthMonitor) {
+		m.onChange = onChange
+	}
+}
+
+func NewHealthMonitor(endpoint *Endpoint, options ...HealthMonitorOption) *HealthMonitor {
+	m := &HealthMonitor{
+		endpoint: endpoint,
+		interval: defaultMonitorInterval,
+		timeout:  defaultMonitorTimeout,
+		rise:     1,
+		fall:     1,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// Healthy returns the current health, it's false before the first result.
+func (m *HealthMonitor) Healthy() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.known && m.healthy
+}
+
+func (m *HealthMonitor) observe(err error) {
+	healthy := err == nil
+	m.mu.Lock()
+	if healthy {
+		m.successes, m.failures = m.successes+1, 0
+	} else {
+		m.successes, m.failures = 0, m.failures+1
+	}
+	changed := !m.known ||
+		(healthy && !m.healthy && m.successes >= m.rise) ||
+		(!healthy && m.healthy && m.failures >= m.fall)
+	if changed {
+		m.known, m.healthy = true, healthy
+	}
+	m.mu.Unlock()
+	if changed && m.onChange != nil {
+		m.onChange(HealthEvent{Endpoint: m.endpoint, Healthy: healthy, Err: err, At: time.Now()})
+	}
+}
+
+// Run monitors the endpoint until ctx is done.
+func (m *HealthMonitor) Run(ctx context.Context) error {
+	if m.endpoint.healthy == nil {
+		return errors.New("endpoint has no healthy config")
+	}
+	m.clients = newHealthyClients()
+	defer m.clients.close()
+	if m.endpoint.healthy.Protocol.Is(GRPC) {
+		if err := m.watch(ctx); status.Code(err) != codes.Unimplemented {
+			return err
+		}
+	}
+	m.poll(ctx)
+	return nil
+}
+
+func (m *HealthMonitor) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	return m.endpoint.check(ctx, m.clients)
+}
+
+func (m *HealthMonitor) poll(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.check(ctx); ctx.Err() == nil {
+			m.observe(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type watchResult struct {
+	err    error
+	closed bool
+}
+
+// watch returns nil when ctx is done, or the error of the stream if Watch is not implemented.
+func (m *HealthMonitor) watch(ctx context.Context) error {
+	cc, release, err := m.clients.grpcConn(m.endpoint.healthy)
+	if err != nil {
+		return err
+	}
+	defer release()
+	request := &grpc_health_v1.HealthCheckRequest{Service: grpcHealthService(m.endpoint.healthy, m.endpoint.service)}
+	client := grpc_health_v1.NewHealthClient(cc)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err = m.stream(ctx, client, request, ticker.C); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// stream watches the status until the stream is broken, the status is counted again on each tick.
+func (m *HealthMonitor) stream(ctx context.Context, client grpc_health_v1.HealthClient,
+	request *grpc_health_v1.HealthCheckRequest, tick <-chan time.Time,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Watch(ctx, request)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.observe(err)
+		}
+		return nil
+	}
+	results := make(chan watchResult)
+	go func() {
+		for {
+			response, err := stream.Recv()
+			result := watchResult{err: err, closed: err != nil}
+			if err == nil && response.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+				result.err = errors.New(response.Status.String())
+			}
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+			if result.closed {
+				return
+			}
+		}
+	}()
+
+	var last error
+	received := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case result := <-results:
+			if result.closed && status.Code(result.err) == codes.Unimplemented {
+				return result.err
+			}
+			m.observe(result.err)
+			if result.closed {
+				return nil
+			}
+			last, received = result.err, true
+		case <-tick:
+			if received {
+				m.observe(last)
+			}
+		}
+	}
+}
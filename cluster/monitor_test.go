@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthMonitorWatch(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("user-service", grpc_health_v1.HealthCheckResponse_SERVING)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	addr := lis.Addr().(*net.TCPAddr)
+	target := net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
+	endpoint := NewEndpoint("user-service", addr.IP.String(), uint16(addr.Port), WithHealthy(NewHealthyConfig(GRPC, target)))
+	events := make(chan HealthEvent, 8)
+	monitor := NewHealthMonitor(endpoint,
+		WithMonitorInterval(20*time.Millisecond),
+		WithThresholds(2, 1),
+		WithHealthChangeHandler(func(event HealthEvent) { events <- event }),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- monitor.Run(ctx) }()
+
+	expect := func(healthy bool) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Healthy != healthy {
+				t.Fatalf("expect healthy %v, got %v: %v", healthy, event.Healthy, event.Err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect healthy %v", healthy)
+		}
+	}
+	expect(true)
+	healthServer.SetServingStatus("user-service", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	expect(false)
+	if monitor.Healthy() {
+		t.Error("monitor is healthy")
+	}
+	healthServer.SetServingStatus("user-service", grpc_health_v1.HealthCheckResponse_SERVING)
+	expect(true)
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHealthMonitorOptions(t *testing.T) {
+	monitor := NewHealthMonitor(NewEndpoint("user-service", "127.0.0.1", 8080),
+		WithMonitorInterval(0), WithMonitorTimeout(-time.Second))
+	if monitor.interval != defaultMonitorInterval || monitor.timeout != defaultMonitorTimeout {
+		t.Errorf("unexpected interval %s and timeout %s", monitor.interval, monitor.timeout)
+	}
+}
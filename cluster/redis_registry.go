@@ -0,0 +1,337 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisRegistryPrefix = "CLUSTER_REGISTRY"
+	defaultRedisRegistryTTL    = 30 * time.Second
+)
+
+// RedisRegistry stores each endpoint in the key PREFIX:service:nodeID with a TTL, and indexes the node ids
+// of a service in the sorted set PREFIX:service scored by the expiration in unix milliseconds.
+// The value is the JSON format of Endpoint.
+// After Register, the key is refreshed every TTL/3 in background until Deregister or Close is called,
+// so a crashed instance disappears after the TTL.
+//
+// Register and Deregister publish the service name to the channel PREFIX:service:events, Watch subscribes it
+// and also reloads the endpoints every TTL to catch the expired ones.
+// It doesn't rely on keyspace notifications, which are disabled by default on redis server.
+type RedisRegistry struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+
+	debounce     time.Duration
+	onError      func(endpoint *Endpoint, err error)
+	onWatchError func(service string, err error)
+
+	mu         sync.Mutex
+	heartbeats map[string]*redisHeartbeat
+
+	// snapshots are kept only while the services are watched
+	snapshotsMu sync.Mutex
+	snapshots   map[string]*redisSnapshot
+}
+
+// redisSnapshot is the last known endpoints of a watched service.
+type redisSnapshot struct {
+	watchers  int
+	endpoints []*Endpoint
+}
+
+type RedisRegistryOption func(*RedisRegistry)
+
+func WithRedisRegistryPrefix(prefix string) RedisRegistryOption {
+	return func(r *RedisRegistry) {
+		r.prefix = prefix
+	}
+}
+
+func WithRedisRegistryTTL(ttl time.Duration) RedisRegistryOption {
+	return func(r *RedisRegistry) {
+		r.ttl = ttl
+	}
+}
+
+// WithRedisRegistryErrorHandler set the handler of the heartbeat errors.
+func WithRedisRegistryErrorHandler(onError func(endpoint *Endpoint, err error)) RedisRegistryOption {
+	return func(r *RedisRegistry) {
+		r.onError = onError
+	}
+}
+
+// WithRedisRegistryDebounce set the debounce of Watch, the changes within it are sent together.
+func WithRedisRegistryDebounce(debounce time.Duration) RedisRegistryOption {
+	return func(r *RedisRegistry) {
+		r.debounce = debounce
+	}
+}
+
+// WithRedisRegistryWatchErrorHandler set the handler of the reload errors of Watch.
+func WithRedisRegistryWatchErrorHandler(onError func(service string, err error)) RedisRegistryOption {
+	return func(r *RedisRegistry) {
+		r.onWatchError = onError
+	}
+}
+
+func NewRedisRegistry(client redis.UniversalClient, options ...RedisRegistryOption) *RedisRegistry {
+	r := &RedisRegistry{
+		client:     client,
+		prefix:     defaultRedisRegistryPrefix,
+		ttl:        defaultRedisRegistryTTL,
+		debounce:   defaultWatchDebounce,
+		heartbeats: map[string]*redisHeartbeat{},
+		snapshots:  map[string]*redisSnapshot{},
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func (r *RedisRegistry) serviceKey(service string) string {
+	return fmt.Sprintf("%s:%s", r.prefix, service)
+}
+
+func (r *RedisRegistry) endpointKey(service, nodeID string) string {
+	return fmt.Sprintf("%s:%s:%s", r.prefix, service, nodeID)
+}
+
+func (r *RedisRegistry) eventsChannel(service string) string {
+	return fmt.Sprintf("%s:%s:events", r.prefix, service)
+}
+
+func (r *RedisRegistry) write(ctx context.Context, endpoint *Endpoint) error {
+	data, err := json.Marshal(endpoint)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	index := r.serviceKey(endpoint.service)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.endpointKey(endpoint.service, endpoint.nodeID), data, r.ttl)
+		pipe.ZAdd(ctx, index, redis.Z{Score: float64(now.Add(r.ttl).UnixMilli()), Member: endpoint.nodeID})
+		pipe.ZRemRangeByScore(ctx, index, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+		return nil
+	})
+	return err
+}
+
+func (r *RedisRegistry) Register(ctx context.Context, endpoint *Endpoint) error {
+	if err := r.write(ctx, endpoint); err != nil {
+		return err
+	}
+	r.startHeartbeat(endpoint)
+	return r.client.Publish(ctx, r.eventsChannel(endpoint.service), endpoint.service).Err()
+}
+
+// redisHeartbeat refreshes the last registered endpoint of a node, done is closed when the heartbeat exits.
+type redisHeartbeat struct {
+	cancel   context.CancelFunc
+	done     chan struct{}
+	endpoint atomic.Pointer[Endpoint]
+}
+
+// stop cancels the heartbeat and waits for the write in flight, so it can't land after the endpoint is deleted.
+func (h *redisHeartbeat) stop() {
+	h.cancel()
+	<-h.done
+}
+
+func (r *RedisRegistry) startHeartbeat(endpoint *Endpoint) {
+	key := r.endpointKey(endpoint.service, endpoint.nodeID)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok := r.heartbeats[key]; ok {
+		h.endpoint.Store(endpoint)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &redisHeartbeat{cancel: cancel, done: make(chan struct{})}
+	h.endpoint.Store(endpoint)
+	r.heartbeats[key] = h
+	go r.heartbeat(ctx, h)
+}
+
+// heartbeat writes with its own timeout instead of ctx, a canceled write may still be executed by redis
+// after the client gives up, so the write in flight is always completed before the heartbeat exits.
+func (r *RedisRegistry) heartbeat(ctx context.Context, h *redisHeartbeat) {
+	defer close(h.done)
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			endpoint := h.endpoint.Load()
+			writeCtx, cancel := context.WithTimeout(context.Background(), r.ttl/3)
+			err := r.write(writeCtx, endpoint)
+			cancel()
+			if err != nil && ctx.Err() == nil && r.onError != nil {
+				r.onError(endpoint, err)
+			}
+		}
+	}
+}
+
+func (r *RedisRegistry) stopHeartbeat(key string) {
+	r.mu.Lock()
+	h, ok := r.heartbeats[key]
+	delete(r.heartbeats, key)
+	r.mu.Unlock()
+	if ok {
+		h.stop()
+	}
+}
+
+func (r *RedisRegistry) Deregister(ctx context.Context, endpoint *Endpoint) error {
+	key := r.endpointKey(endpoint.service, endpoint.nodeID)
+	r.stopHeartbeat(key)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.ZRem(ctx, r.serviceKey(endpoint.service), endpoint.nodeID)
+		pipe.Publish(ctx, r.eventsChannel(endpoint.service), endpoint.service)
+		return nil
+	})
+	return err
+}
+
+// load reads the unexpired endpoints of the service from redis.
+func (r *RedisRegistry) load(ctx context.Context, name string) ([]*Endpoint, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nodes, err := r.client.ZRangeByScore(ctx, r.serviceKey(name), &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return []*Endpoint{}, nil
+	}
+	sort.Strings(nodes)
+	keys := make([]string, len(nodes))
+	for i, nodeID := range nodes {
+		keys[i] = r.endpointKey(name, nodeID)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]*Endpoint, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// expired after ZRANGEBYSCORE
+			continue
+		}
+		endpoint := &Endpoint{}
+		if err = json.Unmarshal([]byte(data), endpoint); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// watchSnapshot returns the snapshot of the service with a watcher added, release removes the watcher
+// and evicts the snapshot after the last one.
+func (r *RedisRegistry) watchSnapshot(name string) (s *redisSnapshot, release func()) {
+	r.snapshotsMu.Lock()
+	defer r.snapshotsMu.Unlock()
+	s, ok := r.snapshots[name]
+	if !ok {
+		s = &redisSnapshot{}
+		r.snapshots[name] = s
+	}
+	s.watchers++
+	return s, func() {
+		r.snapshotsMu.Lock()
+		defer r.snapshotsMu.Unlock()
+		if s.watchers--; s.watchers == 0 && r.snapshots[name] == s {
+			delete(r.snapshots, name)
+		}
+	}
+}
+
+func (r *RedisRegistry) lastSnapshot(name string) []*Endpoint {
+	r.snapshotsMu.Lock()
+	defer r.snapshotsMu.Unlock()
+	if s, ok := r.snapshots[name]; ok {
+		return s.endpoints
+	}
+	return nil
+}
+
+// GetService returns the endpoints of the service ordered by node id.
+// A copy of the snapshot of Watch is returned without calling redis while the service is being watched,
+// the services not watched are loaded from redis on each call.
+func (r *RedisRegistry) GetService(ctx context.Context, name string) ([]*Endpoint, error) {
+	if last := r.lastSnapshot(name); last != nil {
+		return cloneEndpoints(last), nil
+	}
+	return r.load(ctx, name)
+}
+
+func cloneEndpoints(endpoints []*Endpoint) []*Endpoint {
+	c := make([]*Endpoint, len(endpoints))
+	for i, endpoint := range endpoints {
+		c[i] = endpoint.clone()
+	}
+	return c
+}
+
+func (r *RedisRegistry) Watch(ctx context.Context, name string) (<-chan Event, error) {
+	s, release := r.watchSnapshot(name)
+	pubsub := r.client.Subscribe(ctx, r.eventsChannel(name))
+	notify := make(chan struct{}, 1)
+	go func() {
+		for range pubsub.Channel() {
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	w := &watcher{
+		load: func(ctx context.Context) ([]*Endpoint, error) {
+			return r.load(ctx, name)
+		},
+		debounce: r.debounce,
+		resync:   r.ttl,
+		store: func(endpoints []*Endpoint) {
+			r.snapshotsMu.Lock()
+			s.endpoints = endpoints
+			r.snapshotsMu.Unlock()
+		},
+	}
+	if r.onWatchError != nil {
+		w.onError = func(err error) { r.onWatchError(name, err) }
+	}
+	return w.start(ctx, notify, func() {
+		_ = pubsub.Close()
+		release()
+	})
+}
+
+// Close stops all the heartbeats, the endpoints are not deregistered and expire after the TTL.
+func (r *RedisRegistry) Close() error {
+	r.mu.Lock()
+	heartbeats := r.heartbeats
+	r.heartbeats = map[string]*redisHeartbeat{}
+	r.mu.Unlock()
+	for _, h := range heartbeats {
+		h.stop()
+	}
+	return nil
+}
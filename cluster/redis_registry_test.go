@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisRegistry(t *testing.T, options ...RedisRegistryOption) (*RedisRegistry, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	registry := NewRedisRegistry(cli, options...)
+	t.Cleanup(func() {
+		_ = registry.Close()
+		_ = cli.Close()
+	})
+	return registry, server
+}
+
+func TestRedisRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, server := newTestRedisRegistry(t, WithRedisRegistryPrefix("TEST"), WithRedisRegistryTTL(300*time.Millisecond))
+	node1 := NewEndpoint("user-service", "10.0.0.1", 8080, WithNodeID("node-001"), WithMetadata("version", "1.0.0"))
+	node2 := NewEndpoint("user-service", "10.0.0.2", 8080, WithNodeID("node-002"))
+	for _, node := range []*Endpoint{node2, node1} {
+		if err := registry.Register(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the key format is PREFIX:service:nodeID
+	data, err := server.Get("TEST:user-service:node-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := &Endpoint{}
+	if err = json.Unmarshal([]byte(data), stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Address() != "10.0.0.1:8080" || stored.Metadata().Get("version") != "1.0.0" {
+		t.Errorf("unexpected endpoint: %s", data)
+	}
+	if ttl := server.TTL("TEST:user-service:node-001"); ttl <= 0 || ttl > 300*time.Millisecond {
+		t.Errorf("unexpected ttl: %v", ttl)
+	}
+	if members, _ := server.ZMembers("TEST:user-service"); len(members) != 2 {
+		t.Errorf("unexpected index: %v", members)
+	}
+
+	endpoints, err := registry.GetService(ctx, "user-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 2 || endpoints[0].NodeID() != "node-001" || endpoints[1].NodeID() != "node-002" {
+		t.Errorf("unexpected endpoints: %v", endpoints)
+	}
+
+	// the heartbeat refreshes the ttl every TTL/3
+	server.FastForward(250 * time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	if ttl := server.TTL("TEST:user-service:node-001"); ttl <= 100*time.Millisecond {
+		t.Errorf("the ttl is not refreshed: %v", ttl)
+	}
+
+	registry.mu.Lock()
+	heartbeat := registry.heartbeats["TEST:user-service:node-002"]
+	registry.mu.Unlock()
+	if err = registry.Deregister(ctx, node2); err != nil {
+		t.Fatal(err)
+	}
+	// the heartbeat exited before the endpoint is deleted
+	select {
+	case <-heartbeat.done:
+	default:
+		t.Error("the heartbeat is not stopped")
+	}
+	if server.Exists("TEST:user-service:node-002") {
+		t.Error("the endpoint is not deleted")
+	}
+	if members, _ := server.ZMembers("TEST:user-service"); len(members) != 1 || members[0] != "node-001" {
+		t.Errorf("unexpected index: %v", members)
+	}
+	endpoints, _ = registry.GetService(ctx, "user-service")
+	if len(endpoints) != 1 || endpoints[0].NodeID() != "node-001" {
+		t.Errorf("unexpected endpoints: %v", endpoints)
+	}
+
+	// the heartbeats are stopped by Close, and the endpoint expires
+	_ = registry.Close()
+	server.FastForward(time.Second)
+	if server.Exists("TEST:user-service:node-001") {
+		t.Error("the endpoint is not expired")
+	}
+	if endpoints, _ = registry.GetService(ctx, "user-service"); len(endpoints) != 0 {
+		t.Errorf("unexpected endpoints: %v", endpoints)
+	}
+}
+
+func TestRedisRegistryWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry, server := newTestRedisRegistry(t, WithRedisRegistryDebounce(10*time.Millisecond))
+	node1 := NewEndpoint("user-service", "10.0.0.1", 8080, WithNodeID("node-001"))
+	if err := registry.Register(ctx, node1); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := registry.Watch(ctx, "user-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := receiveEvent(t, events)
+	if event.Type != EventAdd || event.Endpoint.NodeID() != "node-001" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+	// the endpoints of the events are not shared with the snapshot
+	event.Endpoint.Metadata().Set("version", "0.0.1")
+	if endpoints, _ := registry.GetService(ctx, "user-service"); endpoints[0].Metadata().Get("version") != "" {
+		t.Error("the snapshot is modified by the event")
+	}
+
+	node2 := NewEndpoint("user-service", "10.0.0.2", 8080, WithNodeID("node-002"))
+	_ = registry.Register(ctx, node2)
+	if event := receiveEvent(t, events); event.Type != EventAdd || event.Endpoint.NodeID() != "node-002" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+
+	node1.Metadata().Set("version", "1.1.0")
+	_ = registry.Register(ctx, node1)
+	if event := receiveEvent(t, events); event.Type != EventUpdate || event.Endpoint.Metadata().Get("version") != "1.1.0" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+
+	_ = registry.Deregister(ctx, node2)
+	if event := receiveEvent(t, events); event.Type != EventRemove || event.Endpoint.NodeID() != "node-002" {
+		t.Errorf("unexpected event: %v %s", event.Type, event.Endpoint.NodeID())
+	}
+
+	// the snapshot is served while redis is down, and the callers can't modify it
+	server.Close()
+	endpoints, err := registry.GetService(ctx, "user-service")
+	if err != nil || len(endpoints) != 1 || endpoints[0].NodeID() != "node-001" {
+		t.Fatalf("unexpected endpoints: %v %v", endpoints, err)
+	}
+	endpoints[0].Metadata().Set("version", "2.0.0")
+	endpoints[0] = node2
+	endpoints, _ = registry.GetService(ctx, "user-service")
+	if endpoints[0].NodeID() != "node-001" || endpoints[0].Metadata().Get("version") != "1.1.0" {
+		t.Errorf("the snapshot is modified: %s %s", endpoints[0].NodeID(), endpoints[0].Metadata().Get("version"))
+	}
+
+	cancel()
+	for range events {
+	}
+	// the snapshot is evicted after the last watcher
+	registry.snapshotsMu.Lock()
+	defer registry.snapshotsMu.Unlock()
+	if len(registry.snapshots) != 0 {
+		t.Errorf("unexpected snapshots: %v", registry.snapshots)
+	}
+}
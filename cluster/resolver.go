@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the scheme of the resolver, e.g. cluster:///user-service.
+const Scheme = "cluster"
+
+// the well-known metadata keys used by the resolver and the balancer.
+const (
+	MetadataZone    = "zone"
+	MetadataVersion = "version"
+	MetadataWeight  = "weight"
+)
+
+type metadataAttributeKey struct{}
+
+// metadataAttribute implements Equal, so the attributes of the addresses can be compared by grpc.
+type metadataAttribute Metadata
+
+func (m metadataAttribute) Equal(o any) bool {
+	other, ok := o.(metadataAttribute)
+	if !ok || len(m) != len(other) {
+		return false
+	}
+	for key, values := range m {
+		if len(values) != len(other[key]) {
+			return false
+		}
+		for i, val := range values {
+			if other[key][i] != val {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MetadataFromAddress returns the Metadata of the endpoint resolved into the address.
+func MetadataFromAddress(addr resolver.Address) Metadata {
+	m, _ := addr.Attributes.Value(metadataAttributeKey{}).(metadataAttribute)
+	return Metadata(m)
+}
+
+// EndpointAddress converts the endpoint into the grpc address with the Metadata as the attribute.
+func EndpointAddress(e *Endpoint) resolver.Address {
+	return resolver.Address{
+		Addr:       e.Address(),
+		Attributes: attributes.New(metadataAttributeKey{}, metadataAttribute(e.metadata)),
+	}
+}
+
+type resolverBuilder struct {
+	discovery Discovery
+}
+
+// NewResolverBuilder returns the resolver.Builder of Scheme which watches the service by the Discovery,
+// register it by resolver.Register or pass it to grpc.WithResolvers, then dial cluster:///user-service.
+func NewResolverBuilder(discovery Discovery) resolver.Builder {
+	return &resolverBuilder{discovery: discovery}
+}
+
+func (b *resolverBuilder) Scheme() string { return Scheme }
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := b.discovery.Watch(ctx, target.Endpoint())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r := &clusterResolver{cc: cc, cancel: cancel, endpoints: map[string]*Endpoint{}}
+	r.apply(events)
+	go r.watch(events)
+	return r, nil
+}
+
+type clusterResolver struct {
+	cc        resolver.ClientConn
+	cancel    context.CancelFunc
+	endpoints map[string]*Endpoint
+}
+
+func (r *clusterResolver) handle(event Event) {
+	switch event.Type {
+	case EventAdd, EventUpdate:
+		r.endpoints[event.Endpoint.nodeID] = event.Endpoint
+	case EventRemove:
+		delete(r.endpoints, event.Endpoint.nodeID)
+	}
+}
+
+// apply handles the pending events and updates the state once.
+func (r *clusterResolver) apply(events <-chan Event) bool {
+pending:
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			r.handle(event)
+		default:
+			break pending
+		}
+	}
+	addresses := make([]resolver.Address, 0, len(r.endpoints))
+	for _, endpoint := range r.endpoints {
+		if endpoint.Serving() {
+			addresses = append(addresses, EndpointAddress(endpoint))
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Addr < addresses[j].Addr })
+	_ = r.cc.UpdateState(resolver.State{Addresses: addresses})
+	return true
+}
+
+func (r *clusterResolver) watch(events <-chan Event) {
+	for event := range events {
+		r.handle(event)
+		if !r.apply(events) {
+			return
+		}
+	}
+}
+
+// ResolveNow does nothing since the changes are pushed by the Discovery.
+func (r *clusterResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *clusterResolver) Close() {
+	r.cancel()
+}
@@ -12,19 +12,58 @@ import (
 )
 
 const (
-	snowflakeNodeMaxIndex        = 1023
 	snowflakeNodeLeaseDuration   = 5 * time.Minute
 	snowflakeNodeLeaseExpiration = time.Hour
 	snowflakeNodeLeasePrefix     = "SNOWFLAKE_NODE_LEASE"
+	// maxSnowflakeLeaseNodeBits bounds the lease keys read by resolveIndexScript to 1024.
+	maxSnowflakeLeaseNodeBits = 10
 )
 
-const script = `local current = redis.call("GET", KEYS[1])
-if current then
-    return current
-else
-    redis.call("SET", KEYS[1], ARGV[1])
-    return ARGV[1]
-end`
+// resolveIndexScript claims the index of the node atomically, it returns the index already owned by the node id,
+// or claims the lowest free index, -1 if all the indexes are in use. The number of the indexes in use is also returned.
+//
+// KEYS: the lease keys of the indexes from 0
+// ARGV[1]: node id
+// ARGV[2]: lease expiration in milliseconds
+var resolveIndexScript = redis.NewScript(`local id = ARGV[1]
+local owned, free, used = -1, -1, 0
+for i = 1, #KEYS do
+    local owner = redis.call("GET", KEYS[i])
+    if owner then
+        used = used + 1
+        if owner == id and owned == -1 then
+            owned = i - 1
+        end
+    elseif free == -1 then
+        free = i - 1
+    end
+end
+local index = owned
+if index == -1 then
+    index = free
+    if index == -1 then
+        return {-1, used}
+    end
+    used = used + 1
+end
+redis.call("SET", KEYS[index + 1], id, "PX", ARGV[2])
+return {index, used}`)
+
+// renewLeaseScript refreshes the lease only if it's still owned by the node id, it returns 1 if renewed.
+var renewLeaseScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+    redis.call("PEXPIRE", KEYS[1], ARGV[2])
+    return 1
+end
+return 0`)
+
+// releaseLeaseScript deletes the lease only if it's still owned by the node id, it returns 1 if deleted.
+var releaseLeaseScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// ErrSnowflakeLeaseLost means the node index is taken by another node, or the lease can't be renewed before expiration.
+var ErrSnowflakeLeaseLost = errors.New("snowflake node lease is lost")
 
 type (
 	SnowflakeNodeRunner interface {
@@ -32,107 +71,327 @@ type (
 		Stop(ctx context.Context) error
 	}
 
+	// SnowflakeNode generates the IDs with the node index leased from redis.
+	//
+	// It used to embed *snowflake.Node, which is replaced by the current *SnowflakeGenerator since the index
+	// changes after the lease is lost and the generator handles the clock moving backwards. Generate is kept,
+	// use Generator to access the generator of the current index.
 	SnowflakeNode struct {
-		*snowflake.Node
 		service string
 		id      string
-		index   int64
 		client  redis.UniversalClient
 
+		onLeaseLost func(err error)
+		haltOnLost  bool
+		generator   []SnowflakeGeneratorOption
+		layout      SnowflakeLayout
+
+		mu        sync.RWMutex
+		node      *SnowflakeGenerator
+		index     int64
+		inUse     int64
+		renewedAt time.Time
+		lost      bool
+		// ready is closed when the generation is resumed after halted.
+		ready  chan struct{}
 		cancel context.CancelFunc
 	}
+
+	SnowflakeNodeOption func(*SnowflakeNode)
 )
 
-func NewSnowflakeNodeWithEndpoint(ctx context.Context, client redis.UniversalClient, endpoint *Endpoint) (
-	*SnowflakeNode, error,
-) {
-	return NewSnowflakeNode(ctx, client, endpoint.service, endpoint.nodeID)
+// WithLeaseLostHandler set the handler called when the lease is lost, the error wraps ErrSnowflakeLeaseLost.
+// A fresh index is acquired by SnowflakeNode.Start after the lease is lost.
+func WithLeaseLostHandler(onLeaseLost func(err error)) SnowflakeNodeOption {
+	return func(s *SnowflakeNode) {
+		s.onLeaseLost = onLeaseLost
+	}
 }
 
-// NewSnowflakeNode
-// 基于 redis SetEx 实现自动分配服务节点序号，保证每个节点序号在服务内唯一，并将节点序号作为 NodeID 创建 snowflake.Node 对象。
-// 注意：获取到节点序号后需要定期刷新（运行SnowflakeNode.Start()），保持当前节点对该序号的持有状态。
-// 警告：节点序号范围为 0~1023，节点数量超过范围将无法分配序号。同时缓存有一小时过期时间，如果服务内所有节点一小时内累计异常重启次数超过1023次，
-// 也可能导致无法分配序号。
-func NewSnowflakeNode(ctx context.Context, client redis.UniversalClient, service, id string) (*SnowflakeNode, error) {
-	index, err := resolveIndex(ctx, client, service, id)
-	if err != nil {
-		return nil, err
+// WithHaltOnLeaseLost blocks the generation after the lease is lost until a fresh index is acquired,
+// otherwise the old index keeps generating IDs which may collide with the new owner.
+func WithHaltOnLeaseLost() SnowflakeNodeOption {
+	return func(s *SnowflakeNode) {
+		s.haltOnLost = true
 	}
-	node, err := snowflake.NewNode(index)
-	if err != nil {
-		return nil, err
+}
+
+// WithGeneratorOptions set the options of the SnowflakeGenerator, e.g. the ClockPolicy and the SnowflakeLayout.
+func WithGeneratorOptions(options ...SnowflakeGeneratorOption) SnowflakeNodeOption {
+	return func(s *SnowflakeNode) {
+		s.generator = append(s.generator, options...)
 	}
-	SetupSnowflakeNode(node)
+}
+
+func NewSnowflakeNodeWithEndpoint(ctx context.Context, client redis.UniversalClient, endpoint *Endpoint,
+	options ...SnowflakeNodeOption,
+) (*SnowflakeNode, error) {
+	return NewSnowflakeNode(ctx, client, endpoint.service, endpoint.nodeID, options...)
+}
+
+// NewSnowflakeNode
+// 基于 redis Lua 脚本实现自动分配服务节点序号，保证每个节点序号在服务内唯一，并将节点序号作为 NodeID 创建 SnowflakeGenerator 对象。
+// 分配时优先取回当前节点 id 已持有的序号（例如 pod 重启后使用相同的 id），否则原子地占用最小的空闲序号，只需一次 redis 往返。
+// 注意：获取到节点序号后需要定期刷新（运行SnowflakeNode.Start()），保持当前节点对该序号的持有状态。
+// 警告：节点序号范围由 SnowflakeLayout.NodeBits 决定（默认且最多 10 位，即 0~1023），节点数量超过范围将无法分配序号。
+// 同时缓存有一小时过期时间，如果服务内所有节点一小时内累计异常重启次数超过序号范围，也可能导致无法分配序号。
+// 设置了 datacenter 字段时，每个 datacenter 独立分配节点序号。
+// 注意：Lua 脚本会访问服务的所有序号 key，在 redis cluster 中 service 需要包含 hash tag（例如 {user-service}），使这些 key 位于同一个 slot。
+func NewSnowflakeNode(ctx context.Context, client redis.UniversalClient, service, id string,
+	options ...SnowflakeNodeOption,
+) (*SnowflakeNode, error) {
 	snode := &SnowflakeNode{
-		Node:    node,
 		service: service,
 		id:      id,
-		index:   index,
 		client:  client,
 	}
+	for _, option := range options {
+		option(snode)
+	}
+	snode.layout = newSnowflakeGenerator(0, snode.generator...).layout
+	if err := snode.layout.Validate(); err != nil {
+		return nil, err
+	}
+	if snode.layout.NodeBits > maxSnowflakeLeaseNodeBits {
+		return nil, fmt.Errorf("node bits of the leased index must be no more than %d", maxSnowflakeLeaseNodeBits)
+	}
+	if err := snode.acquire(ctx); err != nil {
+		return nil, err
+	}
+	setupGenerator(snode.NextID)
 	return snode, nil
 }
 
-func resolveIndex(ctx context.Context, client redis.UniversalClient, service, id string) (int64, error) {
-	var index int64
-	for index < snowflakeNodeMaxIndex {
-		key := fmt.Sprintf("%s:%s:%d", snowflakeNodeLeasePrefix, service, index)
-		success, err := client.SetNX(ctx, key, id, snowflakeNodeLeaseExpiration).Result()
-		if err != nil {
-			return 0, err
-		}
-		if success {
-			return index, nil
+// acquire resolves the index and resumes the generation.
+func (s *SnowflakeNode) acquire(ctx context.Context) error {
+	index, inUse, err := resolveIndex(ctx, s.client, s.leasePrefix(), s.id, s.layout.MaxNode())
+	if err != nil {
+		return err
+	}
+	node, err := NewSnowflakeGenerator(index, s.generator...)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.node != nil {
+		node.resume(s.node)
+	}
+	defer s.mu.Unlock()
+	s.node, s.index, s.inUse = node, index, inUse
+	s.renewedAt, s.lost = time.Now(), false
+	if s.ready != nil {
+		close(s.ready)
+		s.ready = nil
+	}
+	return nil
+}
+
+// resolveIndex returns the index of the node and the number of the indexes in use of the service.
+func resolveIndex(ctx context.Context, client redis.UniversalClient, prefix, id string, maxIndex int64) (int64, int64, error) {
+	keys := make([]string, maxIndex+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s:%d", prefix, i)
+	}
+	result, err := resolveIndexScript.Run(ctx, client, keys, id, snowflakeNodeLeaseExpiration.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(result) != 2 {
+		return 0, 0, fmt.Errorf("unexpected result of resolving node index: %v", result)
+	}
+	if result[0] < 0 {
+		return 0, result[1], fmt.Errorf("node index is out of range")
+	}
+	return result[0], result[1], nil
+}
+
+// Index returns the node index of the snowflake.
+func (s *SnowflakeNode) Index() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index
+}
+
+// InUse returns how many node indexes of the service were in use, including this node, when the index was resolved.
+func (s *SnowflakeNode) InUse() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inUse
+}
+
+// leasePrefix returns the prefix of the lease keys, the nodes of different datacenters have their own indexes.
+func (s *SnowflakeNode) leasePrefix() string {
+	if s.layout.DatacenterBits > 0 {
+		return fmt.Sprintf("%s:%s:dc%d", snowflakeNodeLeasePrefix, s.service, s.layout.Datacenter)
+	}
+	return fmt.Sprintf("%s:%s", snowflakeNodeLeasePrefix, s.service)
+}
+
+func (s *SnowflakeNode) leaseKey() string {
+	return fmt.Sprintf("%s:%d", s.leasePrefix(), s.index)
+}
+
+// Generator returns the generator of the current index, it's replaced when a fresh index is acquired.
+func (s *SnowflakeNode) Generator() *SnowflakeGenerator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.node
+}
+
+// Layout returns the layout of the IDs.
+func (s *SnowflakeNode) Layout() SnowflakeLayout { return s.layout }
+
+// Decompose splits the ID into the fields by the layout of the node.
+func (s *SnowflakeNode) Decompose(id snowflake.ID) SnowflakeParts {
+	return s.layout.Decompose(id)
+}
+
+// NextID generates an ID, it waits for a fresh index if the generation is halted, see WithHaltOnLeaseLost.
+func (s *SnowflakeNode) NextID(ctx context.Context) (snowflake.ID, error) {
+	for {
+		s.mu.RLock()
+		node, ready := s.node, s.ready
+		s.mu.RUnlock()
+		if ready == nil {
+			return node.NextID()
 		}
-		index++
 		select {
 		case <-ctx.Done():
 			return 0, ctx.Err()
-		case <-time.After(10 * time.Millisecond):
-			continue
+		case <-ready:
 		}
 	}
-	return 0, fmt.Errorf("node index is out of range")
 }
 
+// Generate generates an ID, it blocks until a fresh index is acquired if the generation is halted,
+// and panics if the clock moved backwards beyond the ClockPolicy, see NextID.
+func (s *SnowflakeNode) Generate() snowflake.ID {
+	id, err := s.NextID(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// Stats returns the clock drift metrics of the current generator.
+func (s *SnowflakeNode) Stats() DriftStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.node.Stats()
+}
+
+// renew refreshes the lease if it's still owned, or acquires a fresh index after the lease is lost.
+func (s *SnowflakeNode) renew(ctx context.Context) {
+	s.mu.RLock()
+	lost, key, renewedAt := s.lost, s.leaseKey(), s.renewedAt
+	s.mu.RUnlock()
+	if lost {
+		_ = s.acquire(ctx)
+		return
+	}
+	renewed, err := renewLeaseScript.Run(ctx, s.client, []string{key}, s.id, snowflakeNodeLeaseExpiration.Milliseconds()).Bool()
+	switch {
+	case err == nil && renewed:
+		s.mu.Lock()
+		s.renewedAt = time.Now()
+		s.mu.Unlock()
+		return
+	case err == nil:
+		err = fmt.Errorf("%w: %s is owned by another node", ErrSnowflakeLeaseLost, key)
+	case time.Since(renewedAt) >= snowflakeNodeLeaseExpiration-snowflakeNodeLeaseDuration:
+		// the lease may expire before the next round, give it up while it's still owned
+		err = fmt.Errorf("%w: %s is not renewed before expiration: %v", ErrSnowflakeLeaseLost, key, err)
+	default:
+		// retry in the next round while the lease is not expiring
+		return
+	}
+
+	s.mu.Lock()
+	s.lost = true
+	if s.haltOnLost && s.ready == nil {
+		s.ready = make(chan struct{})
+	}
+	s.mu.Unlock()
+	if s.onLeaseLost != nil {
+		s.onLeaseLost(err)
+	}
+	_ = s.acquire(ctx)
+}
+
+// Start renews the lease periodically until ctx is done or Stop is called.
 func (s *SnowflakeNode) Start(ctx context.Context) error {
-	ctx, s.cancel = context.WithCancel(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+	ticker := time.NewTicker(snowflakeNodeLeaseDuration)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(snowflakeNodeLeaseDuration):
-			key := fmt.Sprintf("%s:%s:%d", snowflakeNodeLeasePrefix, s.service, s.index)
-			s.client.Set(ctx, key, s.id, snowflakeNodeLeaseExpiration)
+		case <-ticker.C:
+			s.renew(ctx)
 		}
 	}
 }
 
+// Stop stops renewing and releases the lease if it's still owned by the node.
 func (s *SnowflakeNode) Stop(ctx context.Context) error {
-	defer s.cancel()
-	key := fmt.Sprintf("%s:%s:%d", snowflakeNodeLeasePrefix, s.service, s.index)
-	return s.client.Del(ctx, key).Err()
+	s.mu.RLock()
+	cancel, key := s.cancel, s.leaseKey()
+	s.mu.RUnlock()
+	if cancel != nil {
+		defer cancel()
+	}
+	return releaseLeaseScript.Run(ctx, s.client, []string{key}, s.id).Err()
 }
 
 func (s *SnowflakeNode) Runner() SnowflakeNodeRunner {
 	return s
 }
 
+const (
+	snowflakeIDMaxBackoff = 100 * time.Millisecond
+	snowflakeIDMaxWait    = 5 * time.Second
+)
+
 var (
-	instance     *snowflake.Node
+	instance     func(ctx context.Context) (snowflake.ID, error)
 	instanceOnce sync.Once
 )
 
 func SetupSnowflakeNode(node *snowflake.Node) {
-	instanceOnce.Do(func() { instance = node })
+	setupGenerator(func(context.Context) (snowflake.ID, error) { return node.Generate(), nil })
 }
 
+func setupGenerator(nextID func(ctx context.Context) (snowflake.ID, error)) {
+	instanceOnce.Do(func() { instance = nextID })
+}
+
+// SnowflakeID generates an ID by the node set up, it retries when the clock moved backwards until the clock
+// catches up, and waits for a fresh index if the generation is halted, see WithHaltOnLeaseLost.
+// It panics if the ID is not generated in 5 seconds, or on the other errors.
 func SnowflakeID() snowflake.ID {
 	if instance == nil {
 		panic(errors.New("snowflake node is not initialized"))
 	}
-	return instance.Generate()
+	ctx, cancel := context.WithTimeout(context.Background(), snowflakeIDMaxWait)
+	defer cancel()
+	for backoff := time.Millisecond; ; backoff = min(2*backoff, snowflakeIDMaxBackoff) {
+		id, err := instance(ctx)
+		if err == nil {
+			return id
+		}
+		if !errors.Is(err, ErrClockMovedBackwards) {
+			panic(err)
+		}
+		select {
+		case <-ctx.Done():
+			panic(err)
+		case <-time.After(backoff):
+		}
+	}
 }
 
 func SnowflakeIDInt64() int64 {
@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+const defaultClockMaxWait = time.Second
+
+// ErrClockMovedBackwards is returned when the clock is behind the last generated ID.
+var ErrClockMovedBackwards = errors.New("clock moved backwards")
+
+// ClockPolicy decides how the generator handles the clock moved backwards.
+type ClockPolicy int
+
+const (
+	// ClockWait sleeps until the clock catches up, ErrClockMovedBackwards is returned if the drift exceeds the max wait.
+	ClockWait ClockPolicy = iota
+	// ClockError returns ErrClockMovedBackwards immediately.
+	ClockError
+	// ClockBorrow keeps generating by a logical clock starting from the last timestamp while the clock is behind,
+	// it borrows the next milliseconds when the sequence is exhausted, the logical clock is released when the clock
+	// catches up. The logical clock never runs ahead of the clock by more than the max wait, ErrClockMovedBackwards
+	// is returned for a larger drift, and it waits for the clock when the lead reaches the max wait.
+	ClockBorrow
+)
+
+// DriftStats is the metrics of the clock moved backwards.
+type DriftStats struct {
+	// Backwards is how many times the clock is found moving backwards, the lead borrowed by ClockBorrow
+	// is not counted.
+	Backwards uint64
+	// MaxDrift is the max backwards drift.
+	MaxDrift time.Duration
+	// Waited is the total time slept by ClockWait.
+	Waited time.Duration
+	// Borrowed is how many milliseconds are borrowed by ClockBorrow.
+	Borrowed uint64
+	// Errors is how many times ErrClockMovedBackwards is returned.
+	Errors uint64
+}
+
+// SnowflakeGenerator generates the snowflake IDs of a node, the layout is DefaultSnowflakeLayout by default,
+// which is the same as bwmarrin/snowflake, so the IDs can be decomposed by snowflake.ID as well.
+type SnowflakeGenerator struct {
+	node      int64
+	layout    SnowflakeLayout
+	policy    ClockPolicy
+	maxWait   time.Duration
+	monotonic bool
+	onDrift   func(drift time.Duration)
+
+	// now returns the current time, the start is used by the monotonic clock.
+	now   func() time.Time
+	start time.Time
+
+	// last is the timestamp of the last ID, seen is the last timestamp read from the clock,
+	// last is ahead of seen only while ClockBorrow is borrowing.
+	mu       sync.Mutex
+	last     int64
+	seen     int64
+	sequence int64
+	stats    DriftStats
+}
+
+type SnowflakeGeneratorOption func(*SnowflakeGenerator)
+
+// WithSnowflakeLayout set the layout of the IDs.
+func WithSnowflakeLayout(layout SnowflakeLayout) SnowflakeGeneratorOption {
+	return func(g *SnowflakeGenerator) {
+		g.layout = layout
+	}
+}
+
+// WithClockPolicy set the policy of the clock moved backwards and the max wait of ClockWait,
+// which is also the max lead of the logical clock of ClockBorrow.
+func WithClockPolicy(policy ClockPolicy, maxWait time.Duration) SnowflakeGeneratorOption {
+	return func(g *SnowflakeGenerator) {
+		g.policy = policy
+		g.maxWait = maxWait
+	}
+}
+
+// WithMonotonicClock generates the timestamps by the monotonic clock elapsed since the generator is created,
+// so the adjustments of the wall clock after that are ignored, see ClockDrift. It's the default as bwmarrin/snowflake.
+func WithMonotonicClock() SnowflakeGeneratorOption {
+	return func(g *SnowflakeGenerator) {
+		g.monotonic = true
+	}
+}
+
+// WithWallClock generates the timestamps by the wall clock, so the IDs follow the adjustments of the clock,
+// and the clock moving backwards is handled by the ClockPolicy.
+func WithWallClock() SnowflakeGeneratorOption {
+	return func(g *SnowflakeGenerator) {
+		g.monotonic = false
+	}
+}
+
+// WithClockDriftHandler set the handler called with the drift when the clock moved backwards, e.g. to export metrics.
+func WithClockDriftHandler(onDrift func(drift time.Duration)) SnowflakeGeneratorOption {
+	return func(g *SnowflakeGenerator) {
+		g.onDrift = onDrift
+	}
+}
+
+func NewSnowflakeGenerator(node int64, options ...SnowflakeGeneratorOption) (*SnowflakeGenerator, error) {
+	g := newSnowflakeGenerator(node, options...)
+	if err := g.layout.Validate(); err != nil {
+		return nil, err
+	}
+	if node < 0 || node > g.layout.MaxNode() {
+		return nil, fmt.Errorf("node number must be between 0 and %d", g.layout.MaxNode())
+	}
+	g.start = g.now()
+	return g, nil
+}
+
+func newSnowflakeGenerator(node int64, options ...SnowflakeGeneratorOption) *SnowflakeGenerator {
+	g := &SnowflakeGenerator{
+		node:      node,
+		layout:    DefaultSnowflakeLayout,
+		maxWait:   defaultClockMaxWait,
+		monotonic: true,
+		now:       time.Now,
+	}
+	for _, option := range options {
+		option(g)
+	}
+	return g
+}
+
+// timestamp returns the milliseconds since the epoch.
+func (g *SnowflakeGenerator) timestamp() int64 {
+	now := g.now()
+	if g.monotonic {
+		now = g.start.Add(now.Sub(g.start))
+	} else {
+		now = now.Round(0)
+	}
+	return now.Sub(g.layout.Epoch).Milliseconds()
+}
+
+// resume continues from the timestamps of the previous generator, so the clock moved backwards is detected as well.
+func (g *SnowflakeGenerator) resume(previous *SnowflakeGenerator) {
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+	g.last, g.seen = previous.last, previous.seen
+}
+
+// ClockDrift returns how far the wall clock is ahead of the monotonic clock since the generator is created,
+// it's zero with WithWallClock.
+func (g *SnowflakeGenerator) ClockDrift() time.Duration {
+	if !g.monotonic {
+		return 0
+	}
+	now := g.now()
+	return now.Round(0).Sub(g.start.Round(0)) - now.Sub(g.start)
+}
+
+// Stats returns the metrics of the clock moved backwards.
+func (g *SnowflakeGenerator) Stats() DriftStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+func (g *SnowflakeGenerator) drifted(drift time.Duration) {
+	g.stats.Backwards++
+	g.stats.MaxDrift = max(g.stats.MaxDrift, drift)
+	if g.onDrift != nil {
+		g.onDrift(drift)
+	}
+}
+
+func (g *SnowflakeGenerator) NextID() (snowflake.ID, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.timestamp()
+	if now < g.seen {
+		g.drifted(time.Duration(g.seen-now) * time.Millisecond)
+	}
+	g.seen = now
+	if now < g.last {
+		lag := time.Duration(g.last-now) * time.Millisecond
+		switch {
+		case g.policy == ClockWait && lag <= g.maxWait:
+			for now < g.last {
+				time.Sleep(time.Duration(g.last-now) * time.Millisecond)
+				g.stats.Waited += time.Duration(g.last-now) * time.Millisecond
+				now = g.timestamp()
+			}
+			g.seen = now
+		case g.policy == ClockBorrow && lag <= g.maxWait:
+			now = g.last
+		default:
+			g.stats.Errors++
+			return 0, fmt.Errorf("%w by %s", ErrClockMovedBackwards, lag)
+		}
+	}
+
+	if now == g.last {
+		g.sequence = (g.sequence + 1) & g.layout.MaxSequence()
+		if g.sequence == 0 {
+			now = g.next()
+		}
+	} else {
+		g.sequence = 0
+	}
+	id, err := g.layout.compose(now, g.node, g.sequence)
+	if err != nil {
+		return 0, err
+	}
+	g.last = now
+	return id, nil
+}
+
+// next returns the timestamp after the last one when the sequence is exhausted. ClockBorrow borrows it only while
+// the clock is behind the last timestamp and the lead stays in the max wait, otherwise it waits for the clock.
+func (g *SnowflakeGenerator) next() int64 {
+	for {
+		now := g.timestamp()
+		if now > g.last {
+			return now
+		}
+		if g.policy == ClockBorrow && now < g.last && time.Duration(g.last+1-now)*time.Millisecond <= g.maxWait {
+			g.stats.Borrowed++
+			return g.last + 1
+		}
+	}
+}
+
+func (g *SnowflakeGenerator) Layout() SnowflakeLayout { return g.layout }
+
+// Decompose splits the ID into the fields by the layout of the generator.
+func (g *SnowflakeGenerator) Decompose(id snowflake.ID) SnowflakeParts {
+	return g.layout.Decompose(id)
+}
+
+// Generate generates an ID, it panics if NextID returns an error, which happens when the clock moved backwards
+// beyond the ClockPolicy, or the timestamp is out of range.
+func (g *SnowflakeGenerator) Generate() snowflake.ID {
+	id, err := g.NextID()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestGenerator(t *testing.T, clock *fakeClock, options ...SnowflakeGeneratorOption) *SnowflakeGenerator {
+	t.Helper()
+	g, err := NewSnowflakeGenerator(5, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.now = clock.Now
+	return g
+}
+
+func TestSnowflakeGeneratorClockPolicy(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+
+	g := newTestGenerator(t, clock, WithClockPolicy(ClockError, 0))
+	first := g.Generate()
+	if first.Node() != 5 || first.Step() != 0 {
+		t.Errorf("unexpected id %d: node %d, step %d", first, first.Node(), first.Step())
+	}
+	clock.now = clock.now.Add(-10 * time.Millisecond)
+	if _, err := g.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("clock moved backwards is not detected: %v", err)
+	}
+
+	var drifts []time.Duration
+	clock.now = time.Now()
+	g = newTestGenerator(t, clock, WithClockPolicy(ClockBorrow, time.Second),
+		WithClockDriftHandler(func(drift time.Duration) { drifts = append(drifts, drift) }))
+	last := g.Generate()
+	clock.now = clock.now.Add(-10 * time.Millisecond)
+	for i := 0; i < 5000; i++ {
+		id, err := g.NextID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("id %d is not increasing after %d", id, last)
+		}
+		last = id
+	}
+	stats := g.Stats()
+	if stats.Backwards != 1 || stats.MaxDrift != 10*time.Millisecond || stats.Borrowed != 1 || len(drifts) != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// the drift beyond the max lead is not borrowed
+	clock.now = time.Now()
+	g = newTestGenerator(t, clock, WithClockPolicy(ClockBorrow, 5*time.Millisecond))
+	g.Generate()
+	clock.now = clock.now.Add(-10 * time.Millisecond)
+	if _, err := g.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("clock moved backwards is not detected: %v", err)
+	}
+}
+
+func TestSnowflakeGeneratorBurst(t *testing.T) {
+	g, err := NewSnowflakeGenerator(1, WithClockPolicy(ClockBorrow, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last snowflake.ID
+	for i := 0; i < 100000; i++ {
+		id := g.Generate()
+		if id <= last {
+			t.Fatalf("id %d is not increasing after %d", id, last)
+		}
+		last = id
+	}
+	// the exhausted sequences wait for the clock without a backward drift, the logical clock never leads
+	if stats := g.Stats(); stats.Backwards != 0 || stats.Borrowed != 0 || stats.Errors != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if parts := g.Decompose(last); parts.Time.After(time.Now()) {
+		t.Errorf("the logical clock runs ahead: %s", parts.Time)
+	}
+}
+
+func TestSnowflakeGeneratorMonotonic(t *testing.T) {
+	g, err := NewSnowflakeGenerator(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := g.Generate()
+	for i := 0; i < 1000; i++ {
+		id := g.Generate()
+		if id <= last {
+			t.Fatalf("id %d is not increasing after %d", id, last)
+		}
+		last = id
+	}
+	if drift := g.ClockDrift(); drift > time.Second || drift < -time.Second {
+		t.Errorf("unexpected drift %s", drift)
+	}
+}
+
+func TestSnowflakeLayout(t *testing.T) {
+	layout := SnowflakeLayout{
+		Epoch:          time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		DatacenterBits: 3,
+		NodeBits:       5,
+		SequenceBits:   8,
+		Datacenter:     6,
+	}
+	g, err := NewSnowflakeGenerator(31, WithSnowflakeLayout(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Truncate(time.Millisecond)
+	g.Generate()
+	parts := g.Decompose(g.Generate())
+	if parts.Datacenter != 6 || parts.Node != 31 || parts.Sequence != 1 {
+		t.Errorf("unexpected parts: %+v", parts)
+	}
+	if parts.Time.Before(before) || parts.Time.After(time.Now()) {
+		t.Errorf("unexpected time: %s", parts.Time)
+	}
+
+	if _, err = NewSnowflakeGenerator(32, WithSnowflakeLayout(layout)); err == nil {
+		t.Error("node out of range is accepted")
+	}
+	layout.NodeBits = 20
+	if err = layout.Validate(); err == nil {
+		t.Error("too many bits are accepted")
+	}
+	// the time field of the default layout holds about 69 years
+	layout = DefaultSnowflakeLayout
+	for _, epoch := range []time.Time{{}, time.Now().Add(time.Hour), time.Now().AddDate(-70, 0, 0)} {
+		layout.Epoch = epoch
+		if err = layout.Validate(); err == nil {
+			t.Errorf("epoch %s is accepted", epoch)
+		}
+	}
+	for _, timestamp := range []int64{-1, layout.MaxTimestamp() + 1} {
+		if id, err := layout.compose(timestamp, 1, 1); !errors.Is(err, ErrSnowflakeTimeOutOfRange) {
+			t.Errorf("timestamp %d is composed into %d", timestamp, id)
+		}
+	}
+	if id, err := layout.compose(layout.MaxTimestamp(), layout.MaxNode(), layout.MaxSequence()); err != nil || id < 0 {
+		t.Errorf("max timestamp is composed into %d: %v", id, err)
+	}
+
+	id := newTestDefaultID(t)
+	if parts = DefaultSnowflakeLayout.Decompose(id); parts.Node != id.Node() || parts.Sequence != id.Step() ||
+		parts.Time.UnixMilli() != id.Time() {
+		t.Errorf("default layout is not compatible with snowflake.ID: %+v", parts)
+	}
+}
+
+func newTestDefaultID(t *testing.T) snowflake.ID {
+	t.Helper()
+	g, err := NewSnowflakeGenerator(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.Generate()
+}
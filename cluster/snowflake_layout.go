@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+// SnowflakeLayout is the bit layout of the snowflake IDs, from high to low: time, datacenter, node and sequence.
+// The time takes the rest of the 63 bits in milliseconds since the epoch.
+type SnowflakeLayout struct {
+	Epoch          time.Time
+	DatacenterBits uint8
+	NodeBits       uint8
+	SequenceBits   uint8
+	// Datacenter is the value of the datacenter field, it must be zero if DatacenterBits is zero.
+	Datacenter int64
+}
+
+// DefaultSnowflakeLayout is the layout of bwmarrin/snowflake, the IDs can be decomposed by snowflake.ID.
+var DefaultSnowflakeLayout = SnowflakeLayout{
+	Epoch:        time.UnixMilli(snowflake.Epoch),
+	NodeBits:     10,
+	SequenceBits: 12,
+}
+
+// ErrSnowflakeTimeOutOfRange is returned when the timestamp is before the epoch or doesn't fit in the time field.
+var ErrSnowflakeTimeOutOfRange = errors.New("snowflake timestamp is out of range")
+
+// minSnowflakeTimeBits keeps at least about 68 years of the time field.
+const minSnowflakeTimeBits = 41
+
+func (l SnowflakeLayout) Validate() error {
+	if l.NodeBits == 0 || l.SequenceBits == 0 {
+		return fmt.Errorf("node bits and sequence bits must be positive")
+	}
+	if int(l.DatacenterBits)+int(l.NodeBits)+int(l.SequenceBits) > 63-minSnowflakeTimeBits {
+		return fmt.Errorf("datacenter, node and sequence bits must be no more than %d in total", 63-minSnowflakeTimeBits)
+	}
+	if l.Datacenter < 0 || l.Datacenter > l.MaxDatacenter() {
+		return fmt.Errorf("datacenter must be between 0 and %d", l.MaxDatacenter())
+	}
+	if l.Epoch.IsZero() {
+		return fmt.Errorf("epoch must be set")
+	}
+	now := time.Now()
+	if l.Epoch.After(now) {
+		return fmt.Errorf("epoch must not be in the future")
+	}
+	if now.Sub(l.Epoch).Milliseconds() > l.MaxTimestamp() {
+		return fmt.Errorf("%w: the time field of %d bits is exhausted since %s",
+			ErrSnowflakeTimeOutOfRange, 63-l.timeShift(), l.Epoch.Add(time.Duration(l.MaxTimestamp())*time.Millisecond))
+	}
+	return nil
+}
+
+func (l SnowflakeLayout) MaxDatacenter() int64 { return -1 ^ (-1 << l.DatacenterBits) }
+
+// MaxNode is the max node index, which is also the max index of the lease allocator of SnowflakeNode,
+// SnowflakeNode accepts at most 10 node bits.
+func (l SnowflakeLayout) MaxNode() int64 { return -1 ^ (-1 << l.NodeBits) }
+
+func (l SnowflakeLayout) MaxSequence() int64 { return -1 ^ (-1 << l.SequenceBits) }
+
+// MaxTimestamp is the max milliseconds since the epoch the time field can hold.
+func (l SnowflakeLayout) MaxTimestamp() int64 { return -1 ^ (-1 << (63 - l.timeShift())) }
+
+func (l SnowflakeLayout) nodeShift() uint8       { return l.SequenceBits }
+func (l SnowflakeLayout) datacenterShift() uint8 { return l.NodeBits + l.SequenceBits }
+func (l SnowflakeLayout) timeShift() uint8       { return l.DatacenterBits + l.NodeBits + l.SequenceBits }
+
+// compose returns ErrSnowflakeTimeOutOfRange instead of shifting the timestamp into the sign bit.
+func (l SnowflakeLayout) compose(timestamp, node, sequence int64) (snowflake.ID, error) {
+	if timestamp < 0 || timestamp > l.MaxTimestamp() {
+		return 0, fmt.Errorf("%w: %d", ErrSnowflakeTimeOutOfRange, timestamp)
+	}
+	return snowflake.ID(timestamp<<l.timeShift() | l.Datacenter<<l.datacenterShift() | node<<l.nodeShift() | sequence), nil
+}
+
+// SnowflakeParts is the fields of a snowflake ID.
+type SnowflakeParts struct {
+	Time       time.Time
+	Datacenter int64
+	Node       int64
+	Sequence   int64
+}
+
+// Decompose splits the ID generated with the layout into the fields.
+func (l SnowflakeLayout) Decompose(id snowflake.ID) SnowflakeParts {
+	v := id.Int64()
+	return SnowflakeParts{
+		Time:       l.Epoch.Add(time.Duration(v>>l.timeShift()) * time.Millisecond),
+		Datacenter: v >> l.datacenterShift() & l.MaxDatacenter(),
+		Node:       v >> l.nodeShift() & l.MaxNode(),
+		Sequence:   v & l.MaxSequence(),
+	}
+}
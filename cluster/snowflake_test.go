@@ -2,39 +2,26 @@ package cluster
 
 import (
 	"context"
-	"sync"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bwmarrin/snowflake"
 	"github.com/redis/go-redis/v9"
 )
 
-type client struct {
-	redis.UniversalClient
-
-	storage sync.Map
-}
-
-func (c *client) SetNX(ctx context.Context, key string, value any, _ time.Duration) *redis.BoolCmd {
-	_, exist := c.storage.LoadOrStore(key, value)
-	return redis.NewBoolResult(!exist, nil)
-}
-
-func (c *client) Set(_ context.Context, key string, value any, _ time.Duration) *redis.StatusCmd {
-	c.storage.Store(key, value)
-	return redis.NewStatusResult("", nil)
-}
-
-func (c *client) Del(_ context.Context, keys ...string) *redis.IntCmd {
-	for _, key := range keys {
-		c.storage.Delete(key)
-	}
-	return redis.NewIntResult(int64(len(keys)), nil)
+func newTestRedis(t *testing.T) redis.UniversalClient {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = cli.Close() })
+	return cli
 }
 
 func TestSnowflakeNode(t *testing.T) {
 	ctx := context.Background()
-	cli := &client{}
+	cli := newTestRedis(t)
 	node1, err := NewSnowflakeNode(ctx, cli, "service", "node-001")
 	if err != nil {
 		t.Error(err)
@@ -51,6 +38,9 @@ func TestSnowflakeNode(t *testing.T) {
 	if node2.index != 1 {
 		t.Error("node2.index != 1")
 	}
+	if node2.InUse() != 2 {
+		t.Errorf("node2.InUse() = %d", node2.InUse())
+	}
 	if err = node2.Stop(ctx); err != nil {
 		t.Error(err)
 	}
@@ -62,4 +52,172 @@ func TestSnowflakeNode(t *testing.T) {
 	if node3.index != 1 {
 		t.Error("node2.index != 1")
 	}
+	// restarted with the same id
+	node1, err = NewSnowflakeNode(ctx, cli, "service", "node-001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if node1.index != 0 || node1.InUse() != 2 {
+		t.Errorf("node1 reclaimed index %d, %d in use", node1.index, node1.InUse())
+	}
+}
+
+func TestSnowflakeNodeLeaseLost(t *testing.T) {
+	ctx := context.Background()
+	cli := newTestRedis(t)
+	var lost []error
+	node, err := NewSnowflakeNode(ctx, cli, "lease", "node-001",
+		WithHaltOnLeaseLost(),
+		WithLeaseLostHandler(func(err error) { lost = append(lost, err) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node.renew(ctx)
+	if len(lost) != 0 || node.Index() != 0 {
+		t.Fatalf("lease is lost: %v", lost)
+	}
+
+	// the lease expired and was taken by another node
+	cli.Set(ctx, "SNOWFLAKE_NODE_LEASE:lease:0", "node-002", 0)
+	node.renew(ctx)
+	if len(lost) != 1 || !errors.Is(lost[0], ErrSnowflakeLeaseLost) {
+		t.Fatalf("lease lost is not detected: %v", lost)
+	}
+	if node.Index() != 1 {
+		t.Errorf("fresh index is %d", node.Index())
+	}
+	if id, err := node.NextID(ctx); err != nil || id.Node() != 1 {
+		t.Errorf("unexpected id %v: %v", id, err)
+	}
+
+	if err = node.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := cli.Get(ctx, "SNOWFLAKE_NODE_LEASE:lease:0").Result(); owner != "node-002" {
+		t.Errorf("lease of another node is released: %s", owner)
+	}
+	if n, _ := cli.Exists(ctx, "SNOWFLAKE_NODE_LEASE:lease:1").Result(); n != 0 {
+		t.Error("lease is not released")
+	}
+}
+
+func TestSnowflakeNodeOutage(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer cli.Close()
+	var lost []error
+	node, err := NewSnowflakeNode(ctx, cli, "outage", "node-001",
+		WithHaltOnLeaseLost(),
+		WithLeaseLostHandler(func(err error) { lost = append(lost, err) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Generator() == nil {
+		t.Fatal("no generator")
+	}
+
+	// the renewal is retried while the lease is not expiring
+	server.SetError("LOADING redis is loading the dataset in memory")
+	node.renew(ctx)
+	if len(lost) != 0 {
+		t.Fatalf("lease is lost: %v", lost)
+	}
+	if _, err = node.NextID(ctx); err != nil {
+		t.Error(err)
+	}
+
+	// the lease is given up before it expires in the next round
+	node.mu.Lock()
+	node.renewedAt = time.Now().Add(-(snowflakeNodeLeaseExpiration - snowflakeNodeLeaseDuration))
+	node.mu.Unlock()
+	node.renew(ctx)
+	if len(lost) != 1 || !errors.Is(lost[0], ErrSnowflakeLeaseLost) {
+		t.Fatalf("lease lost is not detected: %v", lost)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err = node.NextID(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("generation is not halted: %v", err)
+	}
+
+	// resumed after redis is back
+	server.SetError("")
+	node.renew(ctx)
+	if _, err = node.NextID(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSnowflakeNodeHalt(t *testing.T) {
+	node := &SnowflakeNode{ready: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := node.NextID(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("generation is not halted: %v", err)
+	}
+}
+
+func TestSnowflakeNodeLayout(t *testing.T) {
+	ctx := context.Background()
+	cli := newTestRedis(t)
+	layout := SnowflakeLayout{Epoch: DefaultSnowflakeLayout.Epoch, DatacenterBits: 2, NodeBits: 1, SequenceBits: 12, Datacenter: 1}
+	for i, id := range []string{"node-001", "node-002"} {
+		node, err := NewSnowflakeNode(ctx, cli, "layout", id, WithGeneratorOptions(WithSnowflakeLayout(layout)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parts := node.Decompose(node.Generate()); parts.Node != int64(i) || parts.Datacenter != 1 {
+			t.Errorf("unexpected parts: %+v", parts)
+		}
+	}
+	if _, err := NewSnowflakeNode(ctx, cli, "layout", "node-003", WithGeneratorOptions(WithSnowflakeLayout(layout))); err == nil {
+		t.Error("node index out of range is allocated")
+	}
+	// the indexes of another datacenter are allocated separately
+	layout.Datacenter = 2
+	if _, err := NewSnowflakeNode(ctx, cli, "layout", "node-003", WithGeneratorOptions(WithSnowflakeLayout(layout))); err != nil {
+		t.Error(err)
+	}
+	// the node bits of the lease allocator are bounded
+	layout = SnowflakeLayout{Epoch: DefaultSnowflakeLayout.Epoch, NodeBits: 11, SequenceBits: 10}
+	if _, err := NewSnowflakeNode(ctx, cli, "layout", "node-004", WithGeneratorOptions(WithSnowflakeLayout(layout))); err == nil {
+		t.Error("too many node bits are allowed")
+	}
+}
+
+func TestSnowflakeID(t *testing.T) {
+	saved := instance
+	defer func() { instance = saved }()
+
+	// the clock moving backwards is retried
+	calls := 0
+	instance = func(context.Context) (snowflake.ID, error) {
+		if calls++; calls < 3 {
+			return 0, ErrClockMovedBackwards
+		}
+		return 1, nil
+	}
+	if id := SnowflakeID(); id != 1 || calls != 3 {
+		t.Errorf("unexpected id %d after %d calls", id, calls)
+	}
+
+	// the other errors are not retried
+	calls = 0
+	instance = func(context.Context) (snowflake.ID, error) {
+		calls++
+		return 0, ErrSnowflakeTimeOutOfRange
+	}
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrSnowflakeTimeOutOfRange) || calls != 1 {
+				t.Errorf("unexpected panic %v after %d calls", err, calls)
+			}
+		}()
+		SnowflakeID()
+	}()
 }
@@ -0,0 +1,494 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cro4k/toolkit/clients"
+)
+
+var ErrNoEndpoint = errors.New("no endpoint available")
+
+type BalanceStrategy int
+
+const (
+	RoundRobin BalanceStrategy = iota
+	// LeastOutstanding picks the endpoint with the least in-flight requests.
+	LeastOutstanding
+	// ConsistentHash picks the endpoint by the hash key of the request, the ClientInfo.ID by default,
+	// so the requests of a client are sent to the same endpoint while it's available.
+	ConsistentHash
+)
+
+const (
+	defaultTransportHealthCheckInterval = 10 * time.Second
+	defaultTransportHealthCheckTimeout  = 3 * time.Second
+	defaultTransportMaxRetries          = 1
+	defaultTransportServiceIdleTimeout  = 10 * time.Minute
+	consistentHashReplicas              = 64
+)
+
+// Transport is a http.RoundTripper which sends the requests of http://service-name/... to the endpoints of the service
+// discovered by the Discovery. Only the hosts configured by WithServices or ending with the suffix of WithServiceSuffix
+// are resolved, the others are sent by the base RoundTripper directly.
+// The services not requested within the idle timeout are no longer watched until the next request.
+//
+// The endpoints failing Endpoint.Healthy, which is checked every health check interval, are ejected, so are the
+// endpoints failing a request until the next health check passes. The ejected endpoints are still picked if all the
+// endpoints are ejected. The idempotent requests failed by a transport error are retried on another endpoint.
+type Transport struct {
+	discovery Discovery
+	base      http.RoundTripper
+	strategy  BalanceStrategy
+	hashKey   func(r *http.Request) string
+
+	maxRetries          int
+	healthCheckInterval time.Duration
+	healthCheckTimeout  time.Duration
+	idleTimeout         time.Duration
+
+	serviceNames  map[string]bool
+	serviceSuffix string
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	services map[string]*serviceBalancer
+}
+
+type TransportOption func(*Transport)
+
+// WithTransportBase set the RoundTripper sending the requests, http.DefaultTransport by default.
+func WithTransportBase(base http.RoundTripper) TransportOption {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+func WithBalanceStrategy(strategy BalanceStrategy) TransportOption {
+	return func(t *Transport) {
+		t.strategy = strategy
+	}
+}
+
+// WithHashKey set the hash key of ConsistentHash, the requests without key are balanced by round-robin.
+func WithHashKey(hashKey func(r *http.Request) string) TransportOption {
+	return func(t *Transport) {
+		t.hashKey = hashKey
+	}
+}
+
+// WithMaxRetries set how many times an idempotent request is retried on another endpoint, 1 by default.
+func WithMaxRetries(maxRetries int) TransportOption {
+	return func(t *Transport) {
+		t.maxRetries = maxRetries
+	}
+}
+
+// WithHealthCheck set the interval and the timeout of Endpoint.Healthy, zero interval disables the health check,
+// and the ejected endpoints are never restored.
+func WithHealthCheck(interval, timeout time.Duration) TransportOption {
+	return func(t *Transport) {
+		t.healthCheckInterval = interval
+		t.healthCheckTimeout = timeout
+	}
+}
+
+// WithServices set the hosts resolved as the service names.
+func WithServices(names ...string) TransportOption {
+	return func(t *Transport) {
+		for _, name := range names {
+			t.serviceNames[strings.ToLower(name)] = true
+		}
+	}
+}
+
+// WithServiceSuffix make the hosts ending with the suffix resolved as the service names without the suffix,
+// e.g. user-service.cluster is the service user-service with the suffix .cluster.
+func WithServiceSuffix(suffix string) TransportOption {
+	return func(t *Transport) {
+		t.serviceSuffix = strings.ToLower(suffix)
+	}
+}
+
+// WithServiceIdleTimeout set how long a service is watched without requests, 10 minutes by default,
+// zero means the services are watched until Close.
+func WithServiceIdleTimeout(timeout time.Duration) TransportOption {
+	return func(t *Transport) {
+		t.idleTimeout = timeout
+	}
+}
+
+func clientIDHashKey(r *http.Request) string {
+	if info := clients.FromContext(r.Context()); info != nil {
+		return info.ID
+	}
+	return r.Header.Get(clients.HeaderClientID)
+}
+
+func NewTransport(discovery Discovery, options ...TransportOption) *Transport {
+	t := &Transport{
+		discovery:           discovery,
+		base:                http.DefaultTransport,
+		hashKey:             clientIDHashKey,
+		maxRetries:          defaultTransportMaxRetries,
+		healthCheckInterval: defaultTransportHealthCheckInterval,
+		healthCheckTimeout:  defaultTransportHealthCheckTimeout,
+		idleTimeout:         defaultTransportServiceIdleTimeout,
+		serviceNames:        map[string]bool{},
+		services:            map[string]*serviceBalancer{},
+	}
+	for _, option := range options {
+		option(t)
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	if t.idleTimeout > 0 {
+		go t.evictIdle()
+	}
+	return t
+}
+
+// Close stops watching the services and the health checks.
+func (t *Transport) Close() error {
+	t.cancel()
+	return nil
+}
+
+// serviceName returns the service name of the host if it should be resolved by the Discovery.
+func (t *Transport) serviceName(host string) (string, bool) {
+	if host == "" || net.ParseIP(host) != nil {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return "", false
+	}
+	host = strings.ToLower(host)
+	if t.serviceNames[host] {
+		return host, true
+	}
+	if t.serviceSuffix != "" {
+		if name, ok := strings.CutSuffix(host, t.serviceSuffix); ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// evictIdle stops watching the services without requests within the idle timeout.
+func (t *Transport) evictIdle() {
+	ticker := time.NewTicker(t.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deadline := time.Now().Add(-t.idleTimeout).UnixNano()
+		t.mu.Lock()
+		for name, s := range t.services {
+			if s.lastUsed.Load() < deadline {
+				s.cancel()
+				delete(t.services, name)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *Transport) service(name string) (*serviceBalancer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.services[name]; ok {
+		s.lastUsed.Store(time.Now().UnixNano())
+		return s, nil
+	}
+	if t.ctx.Err() != nil {
+		return nil, errors.New("transport is closed")
+	}
+	ctx, cancel := context.WithCancel(t.ctx)
+	events, err := t.discovery.Watch(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &serviceBalancer{endpoints: map[string]*balancedEndpoint{}, cancel: cancel, clients: newRetainedHealthyClients()}
+	s.lastUsed.Store(time.Now().UnixNano())
+	s.apply(events)
+	go func() {
+		defer s.clients.close()
+		defer cancel()
+		for event := range events {
+			s.handle(event)
+			s.apply(events)
+		}
+	}()
+	if t.healthCheckInterval > 0 {
+		go s.healthCheck(ctx, t.healthCheckInterval, t.healthCheckTimeout)
+	}
+	t.services[name] = s
+	return s, nil
+}
+
+// retryable reports whether the request can be sent again.
+func retryable(r *http.Request) bool {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get(clients.HeaderIdempotencyKey) != ""
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	name, ok := t.serviceName(r.URL.Host)
+	if !ok {
+		return t.base.RoundTrip(r)
+	}
+	s, err := t.service(name)
+	if err != nil {
+		return nil, err
+	}
+	var key string
+	if t.strategy == ConsistentHash && t.hashKey != nil {
+		key = t.hashKey(r)
+	}
+	attempts := 1
+	if t.maxRetries > 0 && retryable(r) {
+		attempts += t.maxRetries
+	}
+	tried := map[*balancedEndpoint]bool{}
+	for attempt := 0; ; attempt++ {
+		endpoint := s.pick(t.strategy, key, tried)
+		if endpoint == nil {
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %s", ErrNoEndpoint, name)
+		}
+		tried[endpoint] = true
+		var response *http.Response
+		response, err = t.send(r, endpoint, attempt > 0)
+		if err == nil {
+			return response, nil
+		}
+		// the failure caused by the caller canceling the request doesn't eject the endpoint
+		if r.Context().Err() != nil {
+			return nil, err
+		}
+		endpoint.ejected.Store(true)
+		if attempt+1 >= attempts {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) send(r *http.Request, endpoint *balancedEndpoint, retry bool) (*http.Response, error) {
+	outgoing := r.Clone(r.Context())
+	outgoing.URL.Host = endpoint.endpoint.Load().Address()
+	if outgoing.Host == "" {
+		outgoing.Host = r.URL.Host
+	}
+	if retry && r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		outgoing.Body = body
+	}
+	endpoint.outstanding.Add(1)
+	response, err := t.base.RoundTrip(outgoing)
+	if err != nil {
+		endpoint.outstanding.Add(-1)
+		return nil, err
+	}
+	response.Body = &outstandingBody{ReadCloser: response.Body, endpoint: endpoint}
+	return response, nil
+}
+
+// outstandingBody finishes the in-flight request of the endpoint when it's closed.
+type outstandingBody struct {
+	io.ReadCloser
+	endpoint *balancedEndpoint
+	once     sync.Once
+}
+
+func (b *outstandingBody) Close() error {
+	b.once.Do(func() { b.endpoint.outstanding.Add(-1) })
+	return b.ReadCloser.Close()
+}
+
+// balancedEndpoint is updated in place by EventUpdate, so the counters survive and the bodies in flight
+// decrement the live one.
+type balancedEndpoint struct {
+	endpoint    atomic.Pointer[Endpoint]
+	outstanding atomic.Int64
+	ejected     atomic.Bool
+}
+
+func newBalancedEndpoint(endpoint *Endpoint) *balancedEndpoint {
+	b := &balancedEndpoint{}
+	b.endpoint.Store(endpoint)
+	return b
+}
+
+type ringNode struct {
+	hash     uint64
+	endpoint *balancedEndpoint
+}
+
+type serviceBalancer struct {
+	// endpoints is only accessed by the watching goroutine, the others read the snapshot.
+	endpoints map[string]*balancedEndpoint
+	// cancel stops watching the service and the health check.
+	cancel   context.CancelFunc
+	lastUsed atomic.Int64
+	// clients are the connections of the health checks, retained by the endpoints and released when they're removed.
+	clients *healthyClients
+
+	mu   sync.RWMutex
+	list []*balancedEndpoint
+	ring []ringNode
+	next atomic.Uint64
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func (s *serviceBalancer) handle(event Event) {
+	nodeID := event.Endpoint.nodeID
+	serving := (event.Type == EventAdd || event.Type == EventUpdate) && event.Endpoint.Serving()
+	old, ok := s.endpoints[nodeID]
+	var from, to *HealthyConfig
+	if ok {
+		from = old.endpoint.Load().healthy
+	}
+	if serving {
+		to = event.Endpoint.healthy
+	}
+	if from == nil || to == nil || healthyClientKey(from) != healthyClientKey(to) {
+		s.clients.retain(to)
+		s.clients.release(from)
+	}
+	switch {
+	case !serving:
+		delete(s.endpoints, nodeID)
+	case ok:
+		old.endpoint.Store(event.Endpoint)
+	default:
+		s.endpoints[nodeID] = newBalancedEndpoint(event.Endpoint)
+	}
+}
+
+// apply handles the pending events and rebuilds the snapshot once.
+func (s *serviceBalancer) apply(events <-chan Event) {
+pending:
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				break pending
+			}
+			s.handle(event)
+		default:
+			break pending
+		}
+	}
+	list := make([]*balancedEndpoint, 0, len(s.endpoints))
+	ring := make([]ringNode, 0, len(s.endpoints)*consistentHashReplicas)
+	for nodeID, endpoint := range s.endpoints {
+		list = append(list, endpoint)
+		for i := 0; i < consistentHashReplicas; i++ {
+			ring = append(ring, ringNode{hash: hashString(nodeID + "#" + strconv.Itoa(i)), endpoint: endpoint})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].endpoint.Load().nodeID < list[j].endpoint.Load().nodeID })
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	s.mu.Lock()
+	s.list, s.ring = list, ring
+	s.mu.Unlock()
+}
+
+func (s *serviceBalancer) snapshot() ([]*balancedEndpoint, []ringNode) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list, s.ring
+}
+
+func (s *serviceBalancer) healthCheck(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		list, _ := s.snapshot()
+		var wg sync.WaitGroup
+		for _, endpoint := range list {
+			wg.Add(1)
+			go func(endpoint *balancedEndpoint) {
+				defer wg.Done()
+				checkCtx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				endpoint.ejected.Store(endpoint.endpoint.Load().check(checkCtx, s.clients) != nil)
+			}(endpoint)
+		}
+		wg.Wait()
+	}
+}
+
+// pick returns an endpoint not tried, the ejected endpoints are picked only if all the others are ejected.
+func (s *serviceBalancer) pick(strategy BalanceStrategy, key string, tried map[*balancedEndpoint]bool) *balancedEndpoint {
+	list, ring := s.snapshot()
+	for _, ignoreEjected := range []bool{false, true} {
+		available := func(endpoint *balancedEndpoint) bool {
+			return !tried[endpoint] && (ignoreEjected || !endpoint.ejected.Load())
+		}
+		if strategy == ConsistentHash && key != "" && len(ring) > 0 {
+			hash := hashString(key)
+			start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= hash })
+			for i := 0; i < len(ring); i++ {
+				if node := ring[(start+i)%len(ring)]; available(node.endpoint) {
+					return node.endpoint
+				}
+			}
+			continue
+		}
+		offset := int(s.next.Add(1) % uint64(max(len(list), 1)))
+		var picked *balancedEndpoint
+		for i := 0; i < len(list); i++ {
+			endpoint := list[(offset+i)%len(list)]
+			if !available(endpoint) {
+				continue
+			}
+			if strategy != LeastOutstanding {
+				return endpoint
+			}
+			if picked == nil || endpoint.outstanding.Load() < picked.outstanding.Load() {
+				picked = endpoint
+			}
+		}
+		if picked != nil {
+			return picked
+		}
+	}
+	return nil
+}
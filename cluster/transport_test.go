@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cro4k/toolkit/clients"
+)
+
+func startHTTPServer(t *testing.T, name string) *Endpoint {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, name)
+	}))
+	t.Cleanup(server.Close)
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return NewEndpoint("user-service", host, uint16(p), WithNodeID(name))
+}
+
+func get(t *testing.T, client *http.Client, ctx context.Context, url string) string {
+	t.Helper()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return string(body)
+}
+
+func TestTransport(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemoryRegistry()
+	_ = registry.Register(ctx, startHTTPServer(t, "node-001"))
+	_ = registry.Register(ctx, startHTTPServer(t, "node-002"))
+
+	transport := NewTransport(registry, WithServices("user-service"))
+	defer transport.Close()
+	client := &http.Client{Transport: transport}
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		counts[get(t, client, ctx, "http://user-service/users/1")]++
+	}
+	if counts["node-001"] != 2 || counts["node-002"] != 2 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+
+	hashing := NewTransport(registry, WithServiceSuffix(".cluster"), WithBalanceStrategy(ConsistentHash))
+	defer hashing.Close()
+	client = &http.Client{Transport: hashing}
+	clientCtx := clients.WithContext(ctx, &clients.ClientInfo{ID: "client-001"})
+	first := get(t, client, clientCtx, "http://user-service.cluster/users/1")
+	for i := 0; i < 4; i++ {
+		if node := get(t, client, clientCtx, "http://user-service.cluster/users/1"); node != first {
+			t.Errorf("consistent hash picked %s and %s", first, node)
+		}
+	}
+}
+
+func TestTransportRetry(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemoryRegistry()
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := lis.Addr().(*net.TCPAddr).Port
+	_ = lis.Close()
+	_ = registry.Register(ctx, NewEndpoint("user-service", "127.0.0.1", uint16(port), WithNodeID("node-000")))
+	_ = registry.Register(ctx, startHTTPServer(t, "node-001"))
+
+	transport := NewTransport(registry, WithServices("user-service"))
+	defer transport.Close()
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 4; i++ {
+		if node := get(t, client, ctx, "http://user-service/users/1"); node != "node-001" {
+			t.Errorf("unexpected node: %s", node)
+		}
+	}
+}
+
+func TestTransportServiceName(t *testing.T) {
+	transport := NewTransport(NewMemoryRegistry(), WithServices("user-service"), WithServiceSuffix(".svc"))
+	defer transport.Close()
+	for host, want := range map[string]string{
+		"user-service":      "user-service",
+		"User-Service":      "user-service",
+		"order-service.svc": "order-service",
+		"localhost":         "",
+		"example.com":       "",
+		"user-service:80":   "",
+		"10.0.0.1":          "",
+		".svc":              "",
+	} {
+		if name, _ := transport.serviceName(host); name != want {
+			t.Errorf("service name of %s: want %q, got %q", host, want, name)
+		}
+	}
+}
+
+func TestTransportCanceled(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemoryRegistry()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	_ = registry.Register(ctx, NewEndpoint("user-service", host, uint16(p), WithNodeID("node-001")))
+
+	transport := NewTransport(registry, WithServices("user-service"), WithServiceIdleTimeout(50*time.Millisecond))
+	defer transport.Close()
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(timeout, http.MethodGet, "http://user-service/users/1", nil)
+	if _, err := (&http.Client{Transport: transport}).Do(request); err == nil {
+		t.Fatal("the request is not canceled")
+	}
+	s, _ := transport.service("user-service")
+	if list, _ := s.snapshot(); len(list) != 1 || list[0].ejected.Load() {
+		t.Error("the endpoint is ejected by the canceled request")
+	}
+
+	// the idle service is no longer watched
+	time.Sleep(200 * time.Millisecond)
+	transport.mu.Lock()
+	watched := len(transport.services)
+	transport.mu.Unlock()
+	if watched != 0 {
+		t.Errorf("%d services are still watched", watched)
+	}
+}
+
+func TestServiceBalancerRelease(t *testing.T) {
+	s := &serviceBalancer{endpoints: map[string]*balancedEndpoint{}, clients: newRetainedHealthyClients()}
+	defer s.clients.close()
+	endpoint := NewEndpoint("user-service", "127.0.0.1", 8080, WithNodeID("node-001"),
+		WithHealthy(NewHealthyConfig(GRPC, "127.0.0.1:8080")))
+	s.handle(Event{Type: EventAdd, Endpoint: endpoint})
+	_, release, err := s.clients.grpcConn(endpoint.HealthyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// the connection is kept if the health check is not changed, or it's shared by another endpoint
+	s.handle(Event{Type: EventUpdate, Endpoint: endpoint})
+	other := NewEndpoint("user-service", "127.0.0.1", 8081, WithNodeID("node-002"),
+		WithHealthy(NewHealthyConfig(GRPC, "127.0.0.1:8080")))
+	s.handle(Event{Type: EventAdd, Endpoint: other})
+	s.handle(Event{Type: EventRemove, Endpoint: other})
+	if len(s.clients.entries) != 1 {
+		t.Errorf("%d connections are cached", len(s.clients.entries))
+	}
+	s.handle(Event{Type: EventRemove, Endpoint: endpoint})
+	if len(s.clients.entries) != 0 || len(s.endpoints) != 0 {
+		t.Errorf("the connection of the removed endpoint is not closed")
+	}
+}
+
+func TestServiceBalancerUpdate(t *testing.T) {
+	s := &serviceBalancer{endpoints: map[string]*balancedEndpoint{}, clients: newRetainedHealthyClients()}
+	defer s.clients.close()
+	s.handle(Event{Type: EventAdd, Endpoint: NewEndpoint("user-service", "127.0.0.1", 8080, WithNodeID("node-001"))})
+	balanced := s.endpoints["node-001"]
+	balanced.outstanding.Add(1)
+	balanced.ejected.Store(true)
+
+	// the endpoint is updated in place, the counters are kept
+	updated := NewEndpoint("user-service", "127.0.0.2", 8080, WithNodeID("node-001"))
+	s.handle(Event{Type: EventUpdate, Endpoint: updated})
+	if s.endpoints["node-001"] != balanced || balanced.endpoint.Load() != updated {
+		t.Fatal("the endpoint is replaced")
+	}
+	if balanced.outstanding.Load() != 1 || !balanced.ejected.Load() {
+		t.Errorf("the counters are reset: %d %v", balanced.outstanding.Load(), balanced.ejected.Load())
+	}
+}
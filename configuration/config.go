@@ -67,13 +67,17 @@ func LoadWithDriver(ctx context.Context, driver Driver, key string, dst any) err
 		return fmt.Errorf("load config failed: key=%s, contentType=%s, err=%w",
 			key, contentType, err)
 	}
-	unmarshaler := unmarshalers[contentType]
-	if unmarshaler == nil {
-		unmarshaler = unmarshalers[UNKNOWN]
-	}
-	if err = unmarshaler(data, dst); err != nil {
+	if err = unmarshal(data, contentType, dst); err != nil {
 		return fmt.Errorf("load config failed: key=%s, contentType=%s, err=%w",
 			key, contentType, err)
 	}
 	return nil
 }
+
+func unmarshal(data []byte, contentType string, dst any) error {
+	unmarshaler := unmarshalers[contentType]
+	if unmarshaler == nil {
+		unmarshaler = unmarshalers[UNKNOWN]
+	}
+	return unmarshaler(data, dst)
+}
@@ -0,0 +1,58 @@
+package configuration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// WatchWithDriver loads the key from the driver every interval, and calls onChange with a freshly unmarshaled
+// value when the content changes, the first load is done before the first tick.
+// The errors of loading, unmarshalling and onChange are passed to onError if it is not nil, and the watching goes on.
+// It blocks until the context is done.
+func WatchWithDriver[T any](
+	ctx context.Context, driver Driver, key string, interval time.Duration,
+	onChange func(*T) error, onError func(error),
+) error {
+	var last []byte
+	check := func() {
+		data, contentType, err := driver.Load(ctx, key)
+		if err != nil {
+			reportWatchError(onError, fmt.Errorf("watch config failed: key=%s, err=%w", key, err))
+			return
+		}
+		if last != nil && bytes.Equal(last, data) {
+			return
+		}
+		dst := new(T)
+		if err = unmarshal(data, contentType, dst); err != nil {
+			reportWatchError(onError, fmt.Errorf("watch config failed: key=%s, contentType=%s, err=%w",
+				key, contentType, err))
+			return
+		}
+		if err = onChange(dst); err != nil {
+			reportWatchError(onError, fmt.Errorf("watch config failed: key=%s, err=%w", key, err))
+			return
+		}
+		last = data
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+func reportWatchError(onError func(error), err error) {
+	if onError != nil {
+		onError(err)
+	}
+}